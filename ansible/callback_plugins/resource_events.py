@@ -0,0 +1,96 @@
+# -*- coding: utf-8 -*-
+# Writes task results as JSON lines to the file named by RESOURCE_EVENTS_FILE,
+# which the out command reads to report failed tasks and task timings.
+from __future__ import (absolute_import, division, print_function)
+__metaclass__ = type
+
+DOCUMENTATION = '''
+    callback: resource_events
+    type: notification
+    short_description: write task results as JSON lines
+    description:
+      - Writes an event per task result to the file named by RESOURCE_EVENTS_FILE.
+'''
+
+import json
+import os
+import time
+
+from ansible.module_utils.six import string_types
+from ansible.plugins.callback import CallbackBase
+
+# maximum number of characters recorded per result field
+MAX_FIELD_LENGTH = 64 * 1024
+
+# result fields recorded, terraform module results include command and workspace
+RESULT_FIELDS = ('msg', 'rc', 'stdout', 'stderr', 'command', 'workspace', 'changed')
+
+# item result fields recorded for looped tasks, whose own msg only reports
+# that one or more items failed
+ITEM_FIELDS = ('msg', 'rc', 'stderr', 'failed')
+
+
+def _fields(result, keys):
+    fields = {}
+    for k in keys:
+        v = result.get(k)
+        if v is None:
+            continue
+        if isinstance(v, string_types) and len(v) > MAX_FIELD_LENGTH:
+            v = v[:MAX_FIELD_LENGTH]
+        fields[k] = v
+    return fields
+
+
+class CallbackModule(CallbackBase):
+    CALLBACK_VERSION = 2.0
+    CALLBACK_TYPE = 'notification'
+    CALLBACK_NAME = 'resource_events'
+    CALLBACK_NEEDS_WHITELIST = True
+    CALLBACK_NEEDS_ENABLED = True
+
+    def __init__(self):
+        super(CallbackModule, self).__init__()
+        self.path = os.environ.get('RESOURCE_EVENTS_FILE')
+        self.started = {}
+
+    def _write(self, event):
+        if not self.path:
+            return
+        with open(self.path, 'a') as f:
+            f.write(json.dumps(event, default=str) + '\n')
+
+    def _result(self, result, status, ignored=False):
+        task = result._task
+        start = self.started.get(task._uuid, time.time())
+        fields = _fields(result._result, RESULT_FIELDS)
+        items = result._result.get('results')
+        if isinstance(items, list):
+            fields['results'] = [_fields(i, ITEM_FIELDS) for i in items if isinstance(i, dict)]
+        self._write({
+            'event': 'task',
+            'task': task.get_name(),
+            'action': task.action,
+            'status': status,
+            'ignored': ignored,
+            'duration': time.time() - start,
+            'result': fields,
+        })
+
+    def v2_playbook_on_task_start(self, task, is_conditional):
+        self.started[task._uuid] = time.time()
+
+    def v2_playbook_on_handler_task_start(self, task):
+        self.started[task._uuid] = time.time()
+
+    def v2_runner_on_ok(self, result):
+        self._result(result, 'ok')
+
+    def v2_runner_on_failed(self, result, ignore_errors=False):
+        self._result(result, 'failed', ignore_errors)
+
+    def v2_runner_on_skipped(self, result):
+        self._result(result, 'skipped')
+
+    def v2_runner_on_unreachable(self, result):
+        self._result(result, 'unreachable')
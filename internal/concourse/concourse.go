@@ -0,0 +1,107 @@
+package concourse
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client is a minimal concourse api client
+type Client struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+// Container describes a concourse container
+type Container struct {
+	ID      string `json:"id"`
+	BuildID int    `json:"build_id"`
+	Type    string `json:"type"`
+}
+
+// Build describes a concourse build, start and end times are unix timestamps
+type Build struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	StartTime int64  `json:"start_time"`
+	EndTime   int64  `json:"end_time"`
+}
+
+// Running returns true if the build has not finished yet
+func (b *Build) Running() bool {
+	return b.Status == "pending" || b.Status == "started"
+}
+
+// Finished returns true if the build reports a final status
+func (b *Build) Finished() bool {
+	switch b.Status {
+	case "succeeded", "failed", "errored", "aborted":
+		return true
+	}
+	return false
+}
+
+// NewClient instantiates a new concourse api client, token is optional
+func NewClient(atcURL, token string) *Client {
+	return &Client{
+		url:   strings.TrimSuffix(atcURL, "/"),
+		token: token,
+		http:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Containers returns the containers of a team
+func (c *Client) Containers(team string) ([]Container, error) {
+	var containers []Container
+	if err := c.get(fmt.Sprintf("teams/%s/containers", url.PathEscape(team)), &containers); err != nil {
+		return nil, fmt.Errorf("error listing containers: %v", err)
+	}
+	return containers, nil
+}
+
+// Build returns the build with the given id
+func (c *Client) Build(id int) (*Build, error) {
+	var build Build
+	if err := c.get(fmt.Sprintf("builds/%d", id), &build); err != nil {
+		return nil, fmt.Errorf("error reading build (%d): %v", id, err)
+	}
+	return &build, nil
+}
+
+// JobBuilds returns the most recent builds of a job
+func (c *Client) JobBuilds(team, pipeline, job string) ([]Build, error) {
+	var builds []Build
+	if err := c.get(fmt.Sprintf("teams/%s/pipelines/%s/jobs/%s/builds?limit=100", url.PathEscape(team), url.PathEscape(pipeline), url.PathEscape(job)), &builds); err != nil {
+		return nil, fmt.Errorf("error listing builds of job (%s/%s): %v", pipeline, job, err)
+	}
+	return builds, nil
+}
+
+func (c *Client) get(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/v1/%s", c.url, path), nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+	return nil
+}
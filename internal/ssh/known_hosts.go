@@ -0,0 +1,85 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KnownHosts builds the content of a known_hosts file from static entries and
+// the host keys scanned from hosts, hosts may specify a port as host:port
+func KnownHosts(static string, hosts []string) ([]byte, error) {
+	var buf bytes.Buffer
+	if static != "" {
+		if err := validateKnownHosts([]byte(static)); err != nil {
+			return nil, fmt.Errorf("invalid known_hosts: %v", err)
+		}
+		buf.WriteString(strings.TrimSpace(static))
+		buf.WriteString("\n")
+	}
+	for _, h := range hosts {
+		keys, err := ScanHost(h)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(keys)
+	}
+	return buf.Bytes(), nil
+}
+
+// ScanHost returns the hashed known_hosts entries of host using ssh-keyscan
+func ScanHost(host string) ([]byte, error) {
+	port := "22"
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("ssh-keyscan", "-H", "-p", port, host)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to scan host keys of %s: %v: %s", host, err, strings.TrimSpace(stderr.String()))
+	}
+	if err := validateKnownHosts(stdout.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to scan host keys of %s: %v", host, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// WriteKnownHosts writes a known_hosts file and returns its path
+func WriteKnownHosts(content []byte) (string, error) {
+	path, err := tempFilepath("known_hosts")
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// validate known_hosts content, requiring at least one host key
+func validateKnownHosts(content []byte) error {
+	found := false
+	for len(bytes.TrimSpace(content)) > 0 {
+		var err error
+		_, _, _, _, content, err = ssh.ParseKnownHosts(content)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("no host keys found")
+	}
+	return nil
+}
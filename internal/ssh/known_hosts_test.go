@@ -0,0 +1,50 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestKnownHosts(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	assert.NoError(t, err)
+	line := knownhosts.Line([]string{"github.example.com", "[gitlab.example.com]:2222"}, key)
+
+	cases := []struct {
+		desc   string
+		static string
+		err    bool
+	}{
+		{desc: "static", static: "# internal git hosts\n" + line + "\n"},
+		{desc: "invalid", static: "github.example.com ssh-ed25519 not-a-key", err: true},
+		{desc: "comments only", static: "# nothing here\n", err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			content, err := KnownHosts(c.static, nil)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Contains(t, string(content), line)
+
+			path, err := WriteKnownHosts(content)
+			assert.NoError(t, err)
+			callback, err := knownhosts.New(path)
+			assert.NoError(t, err)
+			remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+			assert.NoError(t, callback("github.example.com:22", remote, key))
+			assert.NoError(t, callback("gitlab.example.com:2222", remote, key))
+		})
+	}
+}
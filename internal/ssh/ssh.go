@@ -49,15 +49,44 @@ func SpawnAgent() (*Agent, error) {
 	}, nil
 }
 
-func (a *Agent) AddKey(pemEncodedKey []byte) error {
-	privateKey, err := ssh.ParseRawPrivateKey(pemEncodedKey)
+// Key describes a private key, optionally protected by a passphrase and
+// accompanied by a certificate in authorized_keys format
+type Key struct {
+	PrivateKey  []byte
+	Passphrase  []byte
+	Certificate []byte
+}
+
+func (a *Agent) AddKey(key Key) error {
+	var privateKey interface{}
+	var err error
+	if len(key.Passphrase) > 0 {
+		privateKey, err = ssh.ParseRawPrivateKeyWithPassphrase(key.PrivateKey, key.Passphrase)
+	} else {
+		privateKey, err = ssh.ParseRawPrivateKey(key.PrivateKey)
+	}
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		return fmt.Errorf("failed to parse key: key is encrypted, passphrase required")
+	}
 	if err != nil {
 		return fmt.Errorf("failed to parse key: %s", err)
 	}
 	if err = a.agent.Add(sshPkg.AddedKey{PrivateKey: privateKey}); err != nil {
 		return err
 	}
-	return nil
+
+	if len(key.Certificate) == 0 {
+		return nil
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(key.Certificate)
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate: %s", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return fmt.Errorf("failed to parse certificate: not a certificate")
+	}
+	return a.agent.Add(sshPkg.AddedKey{PrivateKey: privateKey, Certificate: cert})
 }
 
 func (a *Agent) SSHAuthSock() string {
@@ -0,0 +1,69 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAgentAddKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	der := x509.MarshalPKCS1PrivateKey(rsaKey)
+	plain := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der})
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", der, []byte("secret"), x509.PEMCipherAES256)
+	assert.NoError(t, err)
+	encrypted := pem.EncodeToMemory(block)
+
+	// sign a user certificate for the key
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	ca, err := ssh.NewSignerFromKey(caKey)
+	assert.NoError(t, err)
+	pub, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	assert.NoError(t, err)
+	cert := &ssh.Certificate{
+		Key:             pub,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"git"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	assert.NoError(t, cert.SignCert(rand.Reader, ca))
+
+	cases := []struct {
+		desc string
+		key  Key
+		keys int
+		err  string
+	}{
+		{desc: "plain", key: Key{PrivateKey: plain}, keys: 1},
+		{desc: "passphrase", key: Key{PrivateKey: encrypted, Passphrase: []byte("secret")}, keys: 1},
+		{desc: "missing passphrase", key: Key{PrivateKey: encrypted}, err: "failed to parse key: key is encrypted, passphrase required"},
+		{desc: "certificate", key: Key{PrivateKey: plain, Certificate: ssh.MarshalAuthorizedKey(cert)}, keys: 2},
+		{desc: "not a certificate", key: Key{PrivateKey: plain, Certificate: ssh.MarshalAuthorizedKey(pub)}, err: "failed to parse certificate: not a certificate"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			agent, err := SpawnAgent()
+			assert.NoError(t, err)
+			defer agent.Shutdown()
+
+			err = agent.AddKey(c.key)
+			if c.err != "" {
+				assert.EqualError(t, err, c.err)
+				return
+			}
+			assert.NoError(t, err)
+			keys, err := agent.agent.List()
+			assert.NoError(t, err)
+			assert.Len(t, keys, c.keys)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+)
+
+// Azure stores versions in an Azure Blob Storage container with blob
+// versioning enabled, using the az cli
+type Azure struct {
+	account    string
+	accountKey string
+	container  string
+}
+
+// NewAzure instantiates a new Azure Blob storage
+func NewAzure(cfg *types.Storage) (*Azure, error) {
+	return &Azure{
+		account:    cfg.Account,
+		accountKey: cfg.AccountKey,
+		container:  cfg.Container,
+	}, nil
+}
+
+// Get implements Storage
+func (s *Azure) Get(key, versionID string, w io.Writer) error {
+	dest, err := ioutil.TempFile("", "azure")
+	if err != nil {
+		return err
+	}
+	dest.Close()
+	defer os.Remove(dest.Name())
+
+	args := []string{"--name", key, "--file", dest.Name(), "--output", "none"}
+	if versionID != "" {
+		args = append(args, "--version-id", versionID)
+	}
+	if _, err := s.az("download", args...); err != nil {
+		return fmt.Errorf("error getting %s: %v", s.url(key), err)
+	}
+	return copyFile(dest.Name(), w)
+}
+
+// Put implements Storage
+func (s *Azure) Put(key string, r io.Reader) (string, error) {
+	src, err := tempFile(r)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(src)
+
+	out, err := s.az("upload", "--name", key, "--file", src, "--overwrite", "--output", "json")
+	if err != nil {
+		return "", fmt.Errorf("error uploading %s: %v", s.url(key), err)
+	}
+	var res struct {
+		VersionID string `json:"version_id"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return "", fmt.Errorf("error parsing upload response: %v", err)
+	}
+	if res.VersionID == "" {
+		return "", fmt.Errorf("no version id returned for %s, blob versioning must be enabled", s.url(key))
+	}
+	return res.VersionID, nil
+}
+
+// az executes an az storage blob command against the configured container
+func (s *Azure) az(command string, args ...string) ([]byte, error) {
+	var env []string
+	if s.accountKey != "" {
+		env = append(env, fmt.Sprintf("AZURE_STORAGE_KEY=%s", s.accountKey))
+	}
+	args = append([]string{"storage", "blob", command, "--account-name", s.account, "--container-name", s.container}, args...)
+	stdout, _, err := run(env, "az", args...)
+	return stdout, err
+}
+
+func (s *Azure) url(key string) string {
+	return fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s", s.account, s.container, key)
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// encryptedMagic prefixes every encrypted object
+var encryptedMagic = []byte("ctr-enc-v1\n")
+
+// Encrypted wraps a Storage and encrypts objects client side using envelope
+// encryption: each object is sealed with a fresh AES-256-GCM data key, which is
+// itself encrypted by a KeyProvider and stored alongside the ciphertext
+//
+// layout: magic | uint32 wrapped key length | wrapped key | nonce | ciphertext
+type Encrypted struct {
+	store Storage
+	keys  KeyProvider
+	// return objects written before encryption was enabled as is
+	allowUnencrypted bool
+}
+
+// KeyProvider generates and unwraps data keys
+type KeyProvider interface {
+	// GenerateKey returns a new 256-bit data key in plaintext and wrapped form
+	GenerateKey() (plaintext []byte, wrapped []byte, err error)
+	// DecryptKey unwraps a wrapped data key
+	DecryptKey(wrapped []byte) ([]byte, error)
+}
+
+// NewEncrypted instantiates a new encrypting storage wrapper, unencrypted
+// objects are rejected unless allowUnencrypted is set
+func NewEncrypted(store Storage, keys KeyProvider, allowUnencrypted bool) *Encrypted {
+	return &Encrypted{
+		store:            store,
+		keys:             keys,
+		allowUnencrypted: allowUnencrypted,
+	}
+}
+
+// Get implements Storage
+func (s *Encrypted) Get(key, versionID string, w io.Writer) error {
+	var buf bytes.Buffer
+	if err := s.store.Get(key, versionID, &buf); err != nil {
+		return err
+	}
+	if s.allowUnencrypted && !bytes.HasPrefix(buf.Bytes(), encryptedMagic) {
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	plaintext, err := s.open(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("error decrypting %s: %v", key, err)
+	}
+	_, err = w.Write(plaintext)
+	return err
+}
+
+// Put implements Storage
+func (s *Encrypted) Put(key string, r io.Reader) (string, error) {
+	plaintext, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := s.seal(plaintext)
+	if err != nil {
+		return "", fmt.Errorf("error encrypting %s: %v", key, err)
+	}
+	return s.store.Put(key, bytes.NewReader(sealed))
+}
+
+func (s *Encrypted) seal(plaintext []byte) ([]byte, error) {
+	dataKey, wrapped, err := s.keys.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("error generating data key: %v", err)
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.Write(encryptedMagic)
+	binary.Write(&out, binary.BigEndian, uint32(len(wrapped)))
+	out.Write(wrapped)
+	out.Write(nonce)
+	out.Write(gcm.Seal(nil, nonce, plaintext, encryptedMagic))
+	return out.Bytes(), nil
+}
+
+func (s *Encrypted) open(sealed []byte) ([]byte, error) {
+	if !bytes.HasPrefix(sealed, encryptedMagic) {
+		return nil, fmt.Errorf("object is not encrypted")
+	}
+	sealed = sealed[len(encryptedMagic):]
+	if len(sealed) < 4 {
+		return nil, fmt.Errorf("truncated object")
+	}
+	n := binary.BigEndian.Uint32(sealed)
+	sealed = sealed[4:]
+	if uint32(len(sealed)) < n {
+		return nil, fmt.Errorf("truncated object")
+	}
+	wrapped, sealed := sealed[:n], sealed[n:]
+
+	dataKey, err := s.keys.DecryptKey(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting data key: %v", err)
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("truncated object")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], encryptedMagic)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	identity, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+
+	cfg := &types.Storage{
+		Type:    types.StorageTypeLocal,
+		Path:    dir,
+		Encrypt: "true",
+		Encryption: types.Encryption{
+			Type:     types.EncryptionTypeAge,
+			Key:      identity.Recipient().String(),
+			Identity: identity.String(),
+		},
+	}
+	store, err := New(cfg, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &Encrypted{}, store)
+
+	key := "sre/example-component/concourse-terraform-resource/version.tgz"
+	versionID, err := store.Put(key, bytes.NewBufferString("secret outputs"))
+	assert.NoError(t, err)
+
+	// objects are encrypted at rest
+	raw := &bytes.Buffer{}
+	plain, err := NewLocal(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, plain.Get(key, versionID, raw))
+	assert.NotContains(t, raw.String(), "secret outputs")
+
+	var buf bytes.Buffer
+	assert.NoError(t, store.Get(key, versionID, &buf))
+	assert.Equal(t, "secret outputs", buf.String())
+
+	// tampered objects fail to decrypt
+	sealed := raw.Bytes()
+	sealed[len(sealed)-1] ^= 0xff
+	tampered, err := plain.Put(key, bytes.NewReader(sealed))
+	assert.NoError(t, err)
+	assert.Error(t, store.Get(key, tampered, &buf))
+
+	// unencrypted objects are rejected
+	unencrypted, err := plain.Put(key, bytes.NewBufferString("plaintext"))
+	assert.NoError(t, err)
+	assert.Error(t, store.Get(key, unencrypted, &buf))
+
+	// unless unencrypted reads are allowed, eg. for versions written before
+	// encryption was enabled
+	cfg.Encryption.AllowUnencryptedReads = true
+	migrating, err := New(cfg, nil)
+	assert.NoError(t, err)
+	buf.Reset()
+	assert.NoError(t, migrating.Get(key, unencrypted, &buf))
+	assert.Equal(t, "plaintext", buf.String())
+	buf.Reset()
+	assert.NoError(t, migrating.Get(key, versionID, &buf))
+	assert.Equal(t, "secret outputs", buf.String())
+	assert.Error(t, migrating.Get(key, tampered, &buf))
+	cfg.Encryption.AllowUnencryptedReads = false
+
+	// objects can not be decrypted with another identity
+	other, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	cfg.Encryption.Identity = other.String()
+	otherStore, err := New(cfg, nil)
+	assert.NoError(t, err)
+	assert.Error(t, otherStore.Get(key, versionID, &buf))
+}
+
+func TestKMSKeysEndpoint(t *testing.T) {
+	cfg := &types.Storage{
+		AWSAccessKeyID:     "foo",
+		AWSSecretAccessKey: "bar",
+		Bucket:             "foo",
+		Endpoint:           "http://minio.example.com:9000",
+		PathStyle:          true,
+		Region:             "us-east-1",
+		Encryption:         types.Encryption{Type: types.EncryptionTypeKMS, Key: "alias/versions"},
+	}
+	keys, err := newKeyProvider(cfg, nil)
+	assert.NoError(t, err)
+	if assert.IsType(t, &kmsKeys{}, keys) {
+		assert.Equal(t, "https://kms.us-east-1.amazonaws.com", keys.(*kmsKeys).client.Endpoint)
+	}
+
+	store, err := NewS3(cfg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://minio.example.com:9000", *store.sess.Config.Endpoint)
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+)
+
+var gcsCreatedRegexp = regexp.MustCompile(`Created: gs://\S+#(\d+)`)
+
+// GCS stores versions in a Google Cloud Storage bucket with object versioning
+// enabled, using the gsutil cli
+type GCS struct {
+	bucket      string
+	credentials string
+}
+
+// NewGCS instantiates a new GCS storage
+func NewGCS(cfg *types.Storage) (*GCS, error) {
+	return &GCS{
+		bucket:      cfg.Bucket,
+		credentials: cfg.Credentials,
+	}, nil
+}
+
+// Get implements Storage
+func (s *GCS) Get(key, versionID string, w io.Writer) error {
+	dest, err := ioutil.TempFile("", "gcs")
+	if err != nil {
+		return err
+	}
+	dest.Close()
+	defer os.Remove(dest.Name())
+
+	src := s.url(key)
+	if versionID != "" {
+		src = fmt.Sprintf("%s#%s", src, versionID)
+	}
+	if _, err := s.gsutil("cp", src, dest.Name()); err != nil {
+		return fmt.Errorf("error getting %s: %v", s.url(key), err)
+	}
+	return copyFile(dest.Name(), w)
+}
+
+// Put implements Storage
+func (s *GCS) Put(key string, r io.Reader) (string, error) {
+	src, err := tempFile(r)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(src)
+
+	stderr, err := s.gsutil("cp", "-v", src, s.url(key))
+	if err != nil {
+		return "", fmt.Errorf("error uploading %s: %v", s.url(key), err)
+	}
+	m := gcsCreatedRegexp.FindSubmatch(stderr)
+	if m == nil {
+		return "", fmt.Errorf("no generation returned for %s, object versioning must be enabled", s.url(key))
+	}
+	return string(m[1]), nil
+}
+
+// gsutil executes a gsutil command, authenticating with the configured service
+// account credentials if provided, and returns stderr where gsutil reports
+// progress and created object urls
+func (s *GCS) gsutil(args ...string) ([]byte, error) {
+	if s.credentials != "" {
+		keyFile, err := tempFile(strings.NewReader(s.credentials))
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(keyFile)
+		args = append([]string{"-o", fmt.Sprintf("Credentials:gs_service_key_file=%s", keyFile)}, args...)
+	}
+	_, stderr, err := run(nil, "gsutil", args...)
+	return stderr, err
+}
+
+func (s *GCS) url(key string) string {
+	return fmt.Sprintf("gs://%s/%s", s.bucket, key)
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/adnankobir/concourse-terraform-resource/internal/vault"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// newKeyProvider instantiates the data key provider selected by the storage
+// encryption config
+func newKeyProvider(cfg *types.Storage, vaultCfg *types.VaultSource) (KeyProvider, error) {
+	enc := cfg.Encryption
+	switch enc.Type {
+	case types.EncryptionTypeVaultTransit:
+		mount := enc.Mount
+		if mount == "" {
+			mount = "transit"
+		}
+		return &transitKeys{cfg: vaultCfg, mount: strings.Trim(mount, "/"), key: enc.Key}, nil
+	case types.EncryptionTypeKMS:
+		// the storage endpoint is s3 compatible only, eg. minio, kms requests
+		// use the regional aws endpoint
+		sess, err := awsSession(cfg, vaultCfg, false)
+		if err != nil {
+			return nil, err
+		}
+		return &kmsKeys{client: kms.New(sess), keyID: enc.Key}, nil
+	case types.EncryptionTypeAge:
+		return newAgeKeys(enc.Key, enc.Identity)
+	}
+	return nil, fmt.Errorf("unsupported encryption type (%s)", enc.Type)
+}
+
+// transitKeys generates data keys using a vault transit key
+type transitKeys struct {
+	cfg    *types.VaultSource
+	client *vault.Client
+	mount  string
+	key    string
+}
+
+// GenerateKey implements KeyProvider
+func (k *transitKeys) GenerateKey() ([]byte, []byte, error) {
+	data, err := k.write(fmt.Sprintf("%s/datakey/plaintext/%s", k.mount, k.key), map[string]interface{}{"bits": 256})
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(vault.String(data, "plaintext"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid data key: %v", err)
+	}
+	return plaintext, []byte(vault.String(data, "ciphertext")), nil
+}
+
+// DecryptKey implements KeyProvider
+func (k *transitKeys) DecryptKey(wrapped []byte) ([]byte, error) {
+	data, err := k.write(fmt.Sprintf("%s/decrypt/%s", k.mount, k.key), map[string]interface{}{"ciphertext": string(wrapped)})
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(vault.String(data, "plaintext"))
+}
+
+func (k *transitKeys) write(path string, data interface{}) (map[string]interface{}, error) {
+	if k.client == nil {
+		client, err := vault.Login(k.cfg)
+		if err != nil {
+			return nil, err
+		}
+		k.client = client
+	}
+	return k.client.Write(path, data)
+}
+
+// kmsKeys generates data keys using an aws kms key
+type kmsKeys struct {
+	client *kms.KMS
+	keyID  string
+}
+
+// GenerateKey implements KeyProvider
+func (k *kmsKeys) GenerateKey() ([]byte, []byte, error) {
+	out, err := k.client.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(k.keyID),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return out.Plaintext, out.CiphertextBlob, nil
+}
+
+// DecryptKey implements KeyProvider
+func (k *kmsKeys) DecryptKey(wrapped []byte) ([]byte, error) {
+	out, err := k.client.Decrypt(&kms.DecryptInput{
+		KeyId:          aws.String(k.keyID),
+		CiphertextBlob: wrapped,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// ageKeys generates random data keys encrypted to age recipients
+type ageKeys struct {
+	recipients []age.Recipient
+	identities []age.Identity
+}
+
+func newAgeKeys(recipients, identities string) (*ageKeys, error) {
+	k := &ageKeys{}
+	var err error
+	if k.recipients, err = age.ParseRecipients(strings.NewReader(recipients)); err != nil {
+		return nil, fmt.Errorf("invalid age recipients: %v", err)
+	}
+	if k.identities, err = age.ParseIdentities(strings.NewReader(identities)); err != nil {
+		return nil, fmt.Errorf("invalid age identity: %v", err)
+	}
+	return k, nil
+}
+
+// GenerateKey implements KeyProvider
+func (k *ageKeys) GenerateKey() ([]byte, []byte, error) {
+	plaintext := make([]byte, 32)
+	if _, err := rand.Read(plaintext); err != nil {
+		return nil, nil, err
+	}
+	var wrapped bytes.Buffer
+	w, err := age.Encrypt(&wrapped, k.recipients...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, nil, err
+	}
+	return plaintext, wrapped.Bytes(), nil
+}
+
+// DecryptKey implements KeyProvider
+func (k *ageKeys) DecryptKey(wrapped []byte) ([]byte, error) {
+	r, err := age.Decrypt(bytes.NewReader(wrapped), k.identities...)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
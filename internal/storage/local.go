@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+)
+
+// Local stores versions on the local file system, each version is written to
+// <path>/<key>.versions/<version id>
+type Local struct {
+	path string
+}
+
+// NewLocal instantiates a new local file system storage
+func NewLocal(cfg *types.Storage) (*Local, error) {
+	return &Local{
+		path: cfg.Path,
+	}, nil
+}
+
+// Get implements Storage
+func (s *Local) Get(key, versionID string, w io.Writer) error {
+	if versionID == "" {
+		latest, err := s.latestVersion(key)
+		if err != nil {
+			return fmt.Errorf("error getting %s: %v", key, err)
+		}
+		versionID = latest
+	}
+	if err := copyFile(s.versionPath(key, versionID), w); err != nil {
+		return fmt.Errorf("error getting %s (%s): %v", key, versionID, err)
+	}
+	return nil
+}
+
+// Put implements Storage
+func (s *Local) Put(key string, r io.Reader) (string, error) {
+	dir := s.versionDir(key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating %s: %v", dir, err)
+	}
+
+	// write to a temporary file first so that partial versions are never visible
+	tmp, err := ioutil.TempFile(dir, ".tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("error writing %s: %v", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	versionID := time.Now().UTC().Format("20060102T150405.000000000Z")
+	if err := os.Rename(tmp.Name(), s.versionPath(key, versionID)); err != nil {
+		return "", fmt.Errorf("error writing %s: %v", key, err)
+	}
+	return versionID, nil
+}
+
+func (s *Local) versionDir(key string) string {
+	return filepath.Join(s.path, key+".versions")
+}
+
+func (s *Local) versionPath(key, versionID string) string {
+	return filepath.Join(s.versionDir(key), versionID)
+}
+
+// latestVersion returns the most recent version id of key, version ids are
+// timestamps and sort chronologically
+func (s *Local) latestVersion(key string) (string, error) {
+	files, err := ioutil.ReadDir(s.versionDir(key))
+	if err != nil {
+		return "", err
+	}
+	latest := ""
+	for _, f := range files {
+		if f.Mode().IsRegular() && !strings.HasPrefix(f.Name(), ".") && f.Name() > latest {
+			latest = f.Name()
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no versions found")
+	}
+	return latest, nil
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := New(&types.Storage{
+		Type: types.StorageTypeLocal,
+		Path: dir,
+	}, nil)
+	assert.NoError(t, err)
+
+	key := "sre/example-component/concourse-terraform-resource/version.tgz"
+	v1, err := store.Put(key, bytes.NewBufferString("first"))
+	assert.NoError(t, err)
+	v2, err := store.Put(key, bytes.NewBufferString("second"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, v1, v2)
+
+	var buf bytes.Buffer
+	assert.NoError(t, store.Get(key, v1, &buf))
+	assert.Equal(t, "first", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, store.Get(key, v2, &buf))
+	assert.Equal(t, "second", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, store.Get(key, "", &buf))
+	assert.Equal(t, "second", buf.String())
+
+	assert.Error(t, store.Get(key, "missing", &buf))
+	assert.Error(t, store.Get("missing.tgz", "", &buf))
+}
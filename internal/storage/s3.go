@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/adnankobir/concourse-terraform-resource/internal/vault"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// S3 stores versions in an AWS S3 (or S3-compatible) bucket with versioning enabled
+type S3 struct {
+	bucket      string
+	sseKMSKeyID string
+	sess        *session.Session
+}
+
+// NewS3 instantiates a new S3 storage
+func NewS3(cfg *types.Storage, vaultCfg *types.VaultSource) (*S3, error) {
+	sess, err := awsSession(cfg, vaultCfg, true)
+	if err != nil {
+		return nil, err
+	}
+	return &S3{
+		bucket:      cfg.Bucket,
+		sseKMSKeyID: cfg.SSEKMSKeyID,
+		sess:        sess,
+	}, nil
+}
+
+// awsSession creates an aws session using the storage credential source,
+// endpoint and path style options apply to s3 clients only
+func awsSession(cfg *types.Storage, vaultCfg *types.VaultSource, s3Options bool) (*session.Session, error) {
+	config := aws.NewConfig().
+		WithRegion(cfg.Region)
+	switch cfg.AWSCredentialSource() {
+	case types.CredentialSourceStatic:
+		config = config.WithCredentials(credentials.NewStaticCredentials(cfg.AWSAccessKeyID, cfg.AWSSecretAccessKey, ""))
+	case types.CredentialSourceVault:
+		config = config.WithCredentials(credentials.NewCredentials(&vaultCredentials{
+			cfg:  vaultCfg,
+			path: cfg.VaultPath,
+		}))
+	}
+	if s3Options {
+		config = config.WithS3ForcePathStyle(cfg.PathStyle)
+		if cfg.Endpoint != "" {
+			config = config.WithEndpoint(cfg.Endpoint)
+		}
+	}
+
+	// without explicit credentials the session uses the default credential
+	// chain (environment, web identity, shared config, ECS and EC2 roles)
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating aws session: %v", err)
+	}
+	return sess, nil
+}
+
+// Get implements Storage
+func (s *S3) Get(key, versionID string, w io.Writer) error {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	out, err := s3.New(s.sess).GetObject(input)
+	if err != nil {
+		return fmt.Errorf("error getting s3://%s/%s: %v", s.bucket, key, err)
+	}
+	defer out.Body.Close()
+	_, err = io.Copy(w, out.Body)
+	return err
+}
+
+// Put implements Storage
+func (s *S3) Put(key string, r io.Reader) (string, error) {
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   r,
+	}
+	if s.sseKMSKeyID != "" {
+		input.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+		input.SSEKMSKeyId = aws.String(s.sseKMSKeyID)
+	}
+	out, err := s3manager.NewUploader(s.sess).Upload(input)
+	if err != nil {
+		return "", fmt.Errorf("error uploading s3://%s/%s: %v", s.bucket, key, err)
+	}
+	if out.VersionID == nil || *out.VersionID == "" {
+		return "", fmt.Errorf("no version id returned for s3://%s/%s, bucket versioning must be enabled", s.bucket, key)
+	}
+	return *out.VersionID, nil
+}
+
+// vaultCredentials retrieves aws credentials issued by the vault aws secrets engine
+type vaultCredentials struct {
+	cfg       *types.VaultSource
+	path      string
+	retrieved bool
+}
+
+// Retrieve implements credentials.Provider
+func (p *vaultCredentials) Retrieve() (credentials.Value, error) {
+	client, err := vault.Login(p.cfg)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	data, err := client.Read(p.path)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	p.retrieved = true
+	return credentials.Value{
+		AccessKeyID:     vault.String(data, "access_key"),
+		SecretAccessKey: vault.String(data, "secret_key"),
+		SessionToken:    vault.String(data, "security_token"),
+		ProviderName:    "vault",
+	}, nil
+}
+
+// IsExpired implements credentials.Provider
+func (p *vaultCredentials) IsExpired() bool {
+	return !p.retrieved
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+)
+
+// Storage describes a versioned object store used to persist resource versions
+type Storage interface {
+	// Get writes the object stored at key with the given version to w, an
+	// empty version id selects the latest version
+	Get(key, versionID string, w io.Writer) error
+	// Put stores the contents of r at key and returns the new version id
+	Put(key string, r io.Reader) (string, error)
+}
+
+// New instantiates the storage implementation selected by cfg.Type, wrapped
+// with client side encryption if enabled. Vault configuration is used to issue
+// credentials and encryption keys if required
+func New(cfg *types.Storage, vaultCfg *types.VaultSource) (Storage, error) {
+	var store Storage
+	var err error
+	switch cfg.StorageType() {
+	case types.StorageTypeS3:
+		store, err = NewS3(cfg, vaultCfg)
+	case types.StorageTypeGCS:
+		store, err = NewGCS(cfg)
+	case types.StorageTypeAzure:
+		store, err = NewAzure(cfg)
+	case types.StorageTypeLocal:
+		store, err = NewLocal(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported storage type (%s)", cfg.Type)
+	}
+	if err != nil || !cfg.Encrypt.Enabled() {
+		return store, err
+	}
+
+	keys, err := newKeyProvider(cfg, vaultCfg)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring encryption: %v", err)
+	}
+	return NewEncrypted(store, keys, cfg.Encryption.AllowUnencryptedReads), nil
+}
+
+// run executes a storage cli command and returns its stdout and stderr
+func run(env []string, name string, args ...string) ([]byte, []byte, error) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v: %s", name, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.Bytes(), stderr.Bytes(), nil
+}
+
+// tempFile writes r to a new temporary file and returns its path
+func tempFile(r io.Reader) (string, error) {
+	f, err := ioutil.TempFile("", "storage")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// copyFile writes the contents of the file at path to w
+func copyFile(path string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -1,14 +1,20 @@
 package terraform
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/adnankobir/concourse-terraform-resource/internal/types"
 	"github.com/Jeffail/gabs/v2"
+	"github.com/sirupsen/logrus"
 )
 
 // Ansible manages state for an ansible-playbook invocation
@@ -18,13 +24,32 @@ type Ansible struct {
 	extraVars *gabs.Container
 	stdout    io.Writer
 	playbook  string
+
+	// gracePeriod is the time terraform is given to exit once interrupted
+	gracePeriod time.Duration
+	// heartbeat is the time without output after which progress is logged,
+	// zero disables heartbeats
+	heartbeat time.Duration
+	// unlock, if set, permits force-unlocking stale state locks
+	unlock *lockGuard
+	// retry, if set, retries transient failures
+	retry *retryPolicy
+	// initCache, if set, persists providers and modules between puts
+	initCache *initCache
+	// diagnostics are the terraform errors and warnings of the last run
+	diagnostics []diagnostic
+	// secrets, if set, are extended with the values of sensitive module
+	// variables before running
+	secrets *secrets
 }
 
 // NewAnsible initializes a new ansible playbook command
 func NewAnsible(src *types.Source, out io.Writer, env *types.Environment, playbook string, workdir string) *Ansible {
 	ansible := Ansible{
-		stdout:   out,
-		playbook: playbook,
+		stdout:      out,
+		playbook:    playbook,
+		gracePeriod: types.DefaultGracePeriod,
+		heartbeat:   types.DefaultHeartbeatInterval,
 	}
 
 	// define extra ansible playbook environment variables
@@ -35,6 +60,9 @@ func NewAnsible(src *types.Source, out io.Writer, env *types.Environment, playbo
 		//"ANSIBLE_STDOUT_CALLBACK":         "selective",
 		//"ANSIBLE_LOAD_CALLBACK_PLUGINS":   "1",
 		"ANSIBLE_STDOUT_CALLBACK":         "debug",
+		"ANSIBLE_CALLBACK_PLUGINS":        callbackPluginsDir,
+		"ANSIBLE_CALLBACK_WHITELIST":      "resource_events",
+		"ANSIBLE_CALLBACKS_ENABLED":       "resource_events",
 		"ANSIBLE_DISPLAY_SKIPPED_HOSTS":   "False",
 		"ANSIBLE_HASHI_VAULT_ADDR":        src.Vault.Addr,
 		"ANSIBLE_HASHI_VAULT_AUTH_METHOD": "approle",
@@ -64,19 +92,210 @@ func NewAnsible(src *types.Source, out io.Writer, env *types.Environment, playbo
 	return &ansible
 }
 
-// Run wraps the underlying command run function invocation and handles cleanup
-func (a *Ansible) Run() error {
+// isolate configures the playbook to write generated files, plan files and
+// terraform data into dir, allowing multiple invocations against the same
+// terraform module to run concurrently
+func (a *Ansible) isolate(dir string) {
+	a.extraVars.Set(dir, "terraform_run_dir")
+	a.extraVars.Set(dir, "terraform_output_dir")
+	a.envs = append(a.envs, fmt.Sprintf("TF_DATA_DIR=%s", path.Join(dir, ".terraform")))
+}
+
+// Run wraps the underlying command run function invocation and handles cleanup,
+// once ctx is cancelled terraform is interrupted and given the grace period to
+// exit and release its state lock. Runs failing on a stale state lock are
+// retried once after force-unlocking it, if permitted by the lock guard, and
+// transient failures are retried according to the retry policy.
+func (a *Ansible) Run(ctx context.Context) error {
+	workspace, _ := a.extraVars.Path("terraform_workspace").Data().(string)
+	a.addSensitiveValues()
+	cacheKey, cached := a.restoreInitCache()
+	unlocked := false
+	attempt := 1
+	for {
+		res, err := a.run(ctx)
+		a.diagnostics = res.diagnostics
+		a.extraVars.Delete("terraform_force_unlock_id")
+		if err == nil && cacheKey != "" && !cached {
+			if err := a.initCache.save(cacheKey, a.dataDir()); err != nil {
+				logrus.Warnf("error saving init cache: %v", err)
+			}
+		}
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if !unlocked && a.forceUnlock(workspace, res.lock) {
+			unlocked = true
+			logrus.Warnf("retrying ansible-playbook once for workspace (%s)", workspace)
+			fmt.Fprintf(a.stdout, "\n==== retry after force-unlock (workspace: %s) ====\n", workspace)
+			continue
+		}
+
+		if a.retry == nil || attempt >= a.retry.attempts {
+			return err
+		}
+		if ok, reason := a.retry.retryable(res); !ok {
+			logrus.Infof("not retrying workspace (%s): %s", workspace, reason)
+			return err
+		}
+		delay := a.retry.delay(attempt)
+		logrus.Warnf("attempt %d/%d of workspace (%s) failed in task (%s), retrying in %s", attempt, a.retry.attempts, workspace, res.failedTask, delay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		attempt++
+		fmt.Fprintf(a.stdout, "\n==== attempt %d/%d (workspace: %s) ====\n", attempt, a.retry.attempts, workspace)
+	}
+}
+
+// restoreInitCache restores the init cache into the terraform data dir,
+// returning the cache key and whether the cache was restored
+func (a *Ansible) restoreInitCache() (string, bool) {
+	if a.initCache == nil {
+		return "", false
+	}
+	moduleDir, _ := a.extraVars.Path("terraform_path").Data().(string)
+	key, err := a.initCache.key(moduleDir)
+	if err != nil {
+		logrus.Warnf("error computing init cache key: %v", err)
+		return "", false
+	}
+	if key == "" {
+		logrus.Infof("init cache disabled, no dependency lock file found in %s", moduleDir)
+		return "", false
+	}
+	if !a.initCache.restore(key, a.dataDir()) {
+		return key, false
+	}
+	a.extraVars.Set(true, "terraform_init_cached")
+	return key, true
+}
+
+// addSensitiveValues registers the values assigned to the module's sensitive
+// variables as secrets
+func (a *Ansible) addSensitiveValues() {
+	if a.secrets == nil {
+		return
+	}
+	moduleDir, _ := a.extraVars.Path("terraform_path").Data().(string)
+	vars, _ := a.extraVars.Path("terraform_vars").Data().(map[string]interface{})
+	var varFiles []string
+	if files, ok := a.extraVars.Path("terraform_var_files").Data().([]string); ok {
+		varFiles = files
+	}
+	values, err := sensitiveValues(moduleDir, vars, varFiles)
+	if err != nil {
+		logrus.Warnf("error reading sensitive variables: %v", err)
+	}
+	a.secrets.add(values...)
+}
+
+// dataDir returns the terraform data dir of the playbook
+func (a *Ansible) dataDir() string {
+	for i := len(a.envs) - 1; i >= 0; i-- {
+		if strings.HasPrefix(a.envs[i], "TF_DATA_DIR=") {
+			return strings.TrimPrefix(a.envs[i], "TF_DATA_DIR=")
+		}
+	}
+	moduleDir, _ := a.extraVars.Path("terraform_path").Data().(string)
+	return path.Join(moduleDir, ".terraform")
+}
+
+// forceUnlock configures the next run to force-unlock lock if the lock guard
+// considers it stale
+func (a *Ansible) forceUnlock(workspace string, lock *lockInfo) bool {
+	if a.unlock == nil || lock == nil {
+		return false
+	}
+	stale, reason := a.unlock.stale(lock)
+	if !stale {
+		logrus.Warnf("not force-unlocking state lock (%s) of workspace (%s): %s", lock.ID, workspace, reason)
+		return false
+	}
+	logrus.Warnf("force-unlocking state lock (%s) of workspace (%s) held by %s since %s: %s", lock.ID, workspace, lock.Who, lock.Created, reason)
+	a.extraVars.Set(lock.ID, "terraform_force_unlock_id")
+	return true
+}
+
+// run executes ansible-playbook once, returning the outcome observed in its
+// output and task events. Failures are reported with the phase and first
+// terraform diagnostic of the failed task.
+func (a *Ansible) run(ctx context.Context) (runResult, error) {
+	// restore args so runs can be repeated
+	defer func(args []string) {
+		a.args = args
+	}(a.args)
 	extraVars, err := a.prepareRun()
+	if err != nil {
+		return runResult{}, fmt.Errorf("error writing extra vars: %v", err)
+	}
 	defer os.Remove(extraVars.Name())
+	events, err := ioutil.TempFile("", "events")
 	if err != nil {
-		return fmt.Errorf("error writing extra vars: %v", err)
+		return runResult{}, fmt.Errorf("error creating events file: %v", err)
 	}
+	events.Close()
+	defer os.Remove(events.Name())
 
+	workspace, _ := a.extraVars.Path("terraform_workspace").Data().(string)
+	activity := newActivityWriter(a.stdout)
+	watcher := newOutputWatcher(activity)
 	cmd := exec.Command("ansible-playbook", append(a.args, a.playbook)...)
-	cmd.Stdout = a.stdout
-	cmd.Env = append(os.Environ(), a.envs...)
+	cmd.Stdout = watcher
+	cmd.Stderr = watcher
+	cmd.Env = append(os.Environ(), append(a.envs, fmt.Sprintf("RESOURCE_EVENTS_FILE=%s", events.Name()))...)
+	// run in a dedicated process group so interrupts reach terraform
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
-	return cmd.Run()
+	if err := cmd.Start(); err != nil {
+		return runResult{}, err
+	}
+	if a.heartbeat > 0 {
+		stop := startHeartbeat(activity, a.heartbeat, workspace)
+		defer stop()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		res := watcher.Result()
+		tasks := readEventsFile(events.Name())
+		if len(tasks) > 0 {
+			writeTimings(a.stdout, workspace, tasks)
+		}
+		if failed := failedTask(tasks); err != nil && failed != nil {
+			res.failedTask = failed.Task
+			err = failed.err()
+		}
+		return res, err
+	case <-ctx.Done():
+	}
+
+	reason := "interrupted"
+	if ctx.Err() == context.DeadlineExceeded {
+		reason = "timed out"
+	}
+	logrus.Warnf("%s, interrupting terraform and waiting up to %s for it to exit", reason, a.gracePeriod)
+	interruptProcessGroup(cmd.Process.Pid, done, a.gracePeriod)
+	res := watcher.Result()
+	reportLock(workspace, res.lock)
+	return res, fmt.Errorf("%s: %v", reason, ctx.Err())
+}
+
+// reportLock logs the state lock that may still be held after an interrupt,
+// the output of terraform modules is only printed once their task finishes so
+// the lock of an interrupted plan or apply is usually unknown
+func reportLock(workspace string, lock *lockInfo) {
+	if lock == nil || lock.ID == "" {
+		logrus.Warnf("state of workspace (%s) may still be locked if terraform did not release it, the lock ID is reported by the next plan or apply that fails to acquire it", workspace)
+		return
+	}
+	logrus.Errorf("state of workspace (%s) may still be locked (lock ID: %s), release it with: terraform force-unlock %s", workspace, lock.ID, lock.ID)
 }
 
 // prepare ansible-playbook run
@@ -91,6 +310,7 @@ func (a *Ansible) prepareRun() (*os.File, error) {
 	if err := ioutil.WriteFile(tmpFile.Name(), a.extraVars.Bytes(), 0644); err != nil {
 		return nil, fmt.Errorf("error writing file: %v", err)
 	}
+
 	a.args = append(a.args, "-e", fmt.Sprintf("@%s", tmpFile.Name()))
 
 	return tmpFile, nil
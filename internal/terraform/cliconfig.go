@@ -0,0 +1,116 @@
+package terraform
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+)
+
+// cliConfig describes a generated terraform cli configuration file
+type cliConfig struct {
+	// credentials maps registry hosts to api tokens
+	credentials map[string]string
+	// disableCheckpoint disables upgrade and security bulletin checks
+	disableCheckpoint bool
+	// pluginCacheDir is the absolute path of the shared provider plugin cache
+	pluginCacheDir string
+	// providerInstallation lists provider installation methods in order
+	providerInstallation []types.ProviderInstallation
+}
+
+// environment variables the cli configuration file is exported with, per engine
+var cliConfigEnvs = map[string]string{
+	types.EngineTerraform: "TF_CLI_CONFIG_FILE",
+	types.EngineTofu:      "TOFU_CLI_CONFIG_FILE",
+}
+
+// generate the terraform cli configuration of the source, exporting it to
+// the engine with TF_CLI_CONFIG_FILE or TOFU_CLI_CONFIG_FILE
+func (cmd *Out) setupTerraformCLI(src *types.Source) error {
+	cli := cliConfig{
+		credentials:          map[string]string{},
+		disableCheckpoint:    src.TerraformCLI.CheckpointDisabled(),
+		providerInstallation: src.TerraformCLI.ProviderInstallation,
+	}
+	for host, token := range src.RegistryCredentials {
+		parsed, err := cmd.parseField(token)
+		if err != nil {
+			return fmt.Errorf("error parsing registry credentials (%s): %v", host, err)
+		}
+		cmd.secrets.add(parsed)
+		cli.credentials[host] = parsed
+	}
+
+	if dir := src.TerraformCLI.PluginCacheDir; dir != "" {
+		// relative paths allow cached task outputs to be used as put inputs
+		if !strings.HasPrefix(dir, "/") {
+			dir = path.Join(cmd.args[1], dir)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating plugin cache dir: %v", err)
+		}
+		cli.pluginCacheDir = dir
+		cmd.pluginCacheDir = dir
+	}
+
+	f, err := writeTempFile("terraformrc", cli.render())
+	if err != nil {
+		return fmt.Errorf("error writing terraform cli config: %v", err)
+	}
+	return os.Setenv(cliConfigEnvs[src.TerraformEngine()], f)
+}
+
+// render the cli configuration in hcl
+func (c *cliConfig) render() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "disable_checkpoint = %t\n", c.disableCheckpoint)
+	if c.pluginCacheDir != "" {
+		fmt.Fprintf(&buf, "plugin_cache_dir = %q\n", c.pluginCacheDir)
+	}
+	buf.WriteString("\n")
+
+	if len(c.providerInstallation) > 0 {
+		buf.WriteString("provider_installation {\n")
+		for _, m := range c.providerInstallation {
+			fmt.Fprintf(&buf, "  %s {\n", m.Type)
+			switch m.Type {
+			case types.InstallationFilesystemMirror:
+				fmt.Fprintf(&buf, "    path = %q\n", m.Path)
+			case types.InstallationNetworkMirror:
+				fmt.Fprintf(&buf, "    url = %q\n", m.URL)
+			}
+			if len(m.Include) > 0 {
+				fmt.Fprintf(&buf, "    include = %s\n", hclList(m.Include))
+			}
+			if len(m.Exclude) > 0 {
+				fmt.Fprintf(&buf, "    exclude = %s\n", hclList(m.Exclude))
+			}
+			buf.WriteString("  }\n")
+		}
+		buf.WriteString("}\n\n")
+	}
+
+	hosts := make([]string, 0, len(c.credentials))
+	for host := range c.credentials {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		fmt.Fprintf(&buf, "credentials %q {\n  token = %q\n}\n\n", host, c.credentials[host])
+	}
+	return buf.Bytes()
+}
+
+// render a list of strings in hcl
+func hclList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return fmt.Sprintf("[%s]", strings.Join(quoted, ", "))
+}
@@ -0,0 +1,102 @@
+package terraform
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTerraformCLI(t *testing.T) {
+	cases := []struct {
+		desc   string
+		source string
+		env    string
+		config string
+	}{
+		{
+			desc:   "defaults",
+			source: `{}`,
+			env:    "TF_CLI_CONFIG_FILE",
+			config: "disable_checkpoint = true\n\n",
+		},
+		{
+			desc:   "tofu",
+			source: `{"engine": "tofu", "registry_credentials": {"registry.example.com": "s3cr3t"}}`,
+			env:    "TOFU_CLI_CONFIG_FILE",
+			config: "disable_checkpoint = true\n\ncredentials \"registry.example.com\" {\n  token = \"s3cr3t\"\n}\n\n",
+		},
+		{
+			desc: "mirrors, plugin cache and credentials",
+			source: `{
+				"registry_credentials": {"registry.example.com": "s3cr3t", "app.terraform.io": "t0ken"},
+				"terraform_cli": {
+					"disable_checkpoint": false,
+					"plugin_cache_dir": "cache/plugins",
+					"provider_installation": [
+						{"type": "filesystem_mirror", "path": "/usr/share/terraform/providers", "include": ["example.com/*/*"]},
+						{"type": "network_mirror", "url": "https://mirror.example.com/providers/"},
+						{"type": "direct", "exclude": ["example.com/*/*", "registry.terraform.io/hashicorp/null"]}
+					]
+				}
+			}`,
+			env: "TF_CLI_CONFIG_FILE",
+			config: `disable_checkpoint = false
+plugin_cache_dir = "{{dir}}/cache/plugins"
+
+provider_installation {
+  filesystem_mirror {
+    path = "/usr/share/terraform/providers"
+    include = ["example.com/*/*"]
+  }
+  network_mirror {
+    url = "https://mirror.example.com/providers/"
+  }
+  direct {
+    exclude = ["example.com/*/*", "registry.terraform.io/hashicorp/null"]
+  }
+}
+
+credentials "app.terraform.io" {
+  token = "t0ken"
+}
+
+credentials "registry.example.com" {
+  token = "s3cr3t"
+}
+
+`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("TF_CLI_CONFIG_FILE", "")
+			t.Setenv("TOFU_CLI_CONFIG_FILE", "")
+
+			var src types.Source
+			assert.NoError(t, json.Unmarshal([]byte(c.source), &src))
+			assert.NoError(t, src.TerraformCLI.Validate())
+			out := &Out{args: []string{"/out", dir}}
+			assert.NoError(t, out.setupTerraformCLI(&src))
+
+			f := os.Getenv(c.env)
+			defer os.Remove(f)
+			config, err := ioutil.ReadFile(f)
+			assert.NoError(t, err)
+			assert.Equal(t, strings.ReplaceAll(c.config, "{{dir}}", dir), string(config))
+			if src.TerraformCLI.PluginCacheDir != "" {
+				assert.DirExists(t, path.Join(dir, src.TerraformCLI.PluginCacheDir))
+			}
+		})
+	}
+
+	invalid := types.TerraformCLI{ProviderInstallation: []types.ProviderInstallation{{Type: "network_mirror", URL: "http://mirror"}}}
+	assert.Error(t, invalid.Validate())
+}
@@ -0,0 +1,91 @@
+package terraform
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+)
+
+// configure https git credentials of the source, tokens are registered as
+// secrets
+func (cmd *Out) setupGitCredentials(src *types.Source) error {
+	if len(src.GitCredentials) == 0 {
+		return nil
+	}
+	store, err := cmd.gitCredentialStore(src.GitCredentials)
+	if err != nil {
+		return fmt.Errorf("error parsing git credentials: %v", err)
+	}
+	if err := setupGitCredentials(store); err != nil {
+		return fmt.Errorf("error configuring git credentials: %v", err)
+	}
+	return nil
+}
+
+// render git credentials in git-credential-store format
+func (cmd *Out) gitCredentialStore(creds map[string]types.GitCredential) ([]byte, error) {
+	hosts := make([]string, 0, len(creds))
+	for host := range creds {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var buf bytes.Buffer
+	for _, host := range hosts {
+		c := creds[host]
+		username, err := cmd.parseField(c.Username)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing username (%s): %v", host, err)
+		}
+		if username == "" {
+			username = types.DefaultGitUsername
+		}
+		token, err := cmd.parseField(c.Token)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing token (%s): %v", host, err)
+		}
+		// mask the url encoded form of the token as well
+		cmd.secrets.add(token, strings.TrimPrefix(url.UserPassword("", token).String(), ":"))
+
+		u := url.URL{Scheme: "https", User: url.UserPassword(username, token), Host: host}
+		fmt.Fprintln(&buf, u.String())
+	}
+	return buf.Bytes(), nil
+}
+
+// write the credential store and configure git to use it as credential helper
+func setupGitCredentials(store []byte) error {
+	f, err := writeTempFile("git-credentials", store)
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	git := exec.Command("git", "config", "--global", "credential.helper", fmt.Sprintf("store --file=%s", f))
+	git.Stderr = &stderr
+	if err := git.Run(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// write content to a new private temporary file and return its path
+func writeTempFile(pattern string, content []byte) (string, error) {
+	f, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := f.Chmod(0600); err != nil {
+		return "", err
+	}
+	if _, err := f.Write(content); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,181 @@
+package terraform
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+)
+
+// terraform diagnostic severities
+const (
+	severityError   = "Error"
+	severityWarning = "Warning"
+)
+
+var (
+	diagnosticPattern = regexp.MustCompile(`^(Error|Warning): (.+?)\s*$`)
+	locationPattern   = regexp.MustCompile(`^on (\S+) line (\d+)`)
+	snippetPattern    = regexp.MustCompile(`^(\d+):`)
+)
+
+// diagnostic describes an error or warning reported by terraform
+type diagnostic struct {
+	severity string
+	summary  string
+	file     string
+	start    int
+	end      int
+}
+
+// location returns the file:line range of the diagnostic, if any
+func (d *diagnostic) location() string {
+	switch {
+	case d.file == "":
+		return ""
+	case d.end > d.start:
+		return fmt.Sprintf("%s:%d-%d", d.file, d.start, d.end)
+	}
+	return fmt.Sprintf("%s:%d", d.file, d.start)
+}
+
+func (d *diagnostic) String() string {
+	s := fmt.Sprintf("%s: %s", d.severity, d.summary)
+	if l := d.location(); l != "" {
+		s += fmt.Sprintf(" (%s)", l)
+	}
+	return s
+}
+
+// diagnosticParser extracts the error and warning diagnostics from terraform
+// output lines, as rendered with or without the box drawing characters of
+// terraform >= 0.15. Diagnostics repeated in the output, eg. by the ansible
+// debug callback printing both the module message and stderr, are recorded
+// once.
+type diagnosticParser struct {
+	current *diagnostic
+	seen    map[string]bool
+	diags   []diagnostic
+}
+
+func (p *diagnosticParser) parseLine(line string) {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "╵") {
+		p.flush()
+		return
+	}
+	trimmed = strings.TrimSpace(strings.TrimLeft(trimmed, "│╷"))
+
+	if m := diagnosticPattern.FindStringSubmatch(trimmed); m != nil {
+		p.flush()
+		p.current = &diagnostic{severity: m[1], summary: m[2]}
+		return
+	}
+	if p.current == nil {
+		return
+	}
+	if m := locationPattern.FindStringSubmatch(trimmed); m != nil && p.current.file == "" {
+		p.current.file = m[1]
+		p.current.start, _ = strconv.Atoi(m[2])
+		p.current.end = p.current.start
+		return
+	}
+	if m := snippetPattern.FindStringSubmatch(trimmed); m != nil && p.current.file != "" {
+		if n, _ := strconv.Atoi(m[1]); n > p.current.end {
+			p.current.end = n
+		}
+	}
+}
+
+// flush records the diagnostic being parsed, if not seen before
+func (p *diagnosticParser) flush() {
+	if p.current == nil {
+		return
+	}
+	d := *p.current
+	p.current = nil
+	if p.seen == nil {
+		p.seen = map[string]bool{}
+	}
+	if key := d.String(); !p.seen[key] {
+		p.seen[key] = true
+		p.diags = append(p.diags, d)
+	}
+}
+
+// diagnostics returns the diagnostics parsed so far
+func (p *diagnosticParser) diagnostics() []diagnostic {
+	p.flush()
+	return p.diags
+}
+
+// mergeDiagnostics returns the distinct diagnostics of several runs, errors
+// first
+func mergeDiagnostics(runs ...[]diagnostic) []diagnostic {
+	var errs, warnings []diagnostic
+	seen := map[string]bool{}
+	for _, diags := range runs {
+		for _, d := range diags {
+			key := d.String()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if d.severity == severityError {
+				errs = append(errs, d)
+			} else {
+				warnings = append(warnings, d)
+			}
+		}
+	}
+	return append(errs, warnings...)
+}
+
+// countDiagnostics formats the number of errors and warnings
+func countDiagnostics(diags []diagnostic) string {
+	var errs, warnings int
+	for _, d := range diags {
+		if d.severity == severityError {
+			errs++
+		} else {
+			warnings++
+		}
+	}
+	return fmt.Sprintf("%s, %s", plural(errs, "error"), plural(warnings, "warning"))
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// writeDiagnostics writes a summary of the diagnostics, if any
+func writeDiagnostics(w io.Writer, diags []diagnostic) {
+	if len(diags) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n==== terraform diagnostics: %s ====\n", countDiagnostics(diags))
+	for _, d := range diags {
+		fmt.Fprintln(w, d.String())
+	}
+}
+
+// diagnosticsMetadata returns put metadata summarizing the diagnostics, if any
+func diagnosticsMetadata(diags []diagnostic) []types.Metadata {
+	if len(diags) == 0 {
+		return nil
+	}
+	lines := make([]string, len(diags))
+	for i, d := range diags {
+		lines[i] = d.String()
+	}
+	return []types.Metadata{
+		{Name: "diagnostics", Value: countDiagnostics(diags)},
+		{Name: "diagnostics_summary", Value: strings.Join(lines, "\n")},
+	}
+}
@@ -0,0 +1,101 @@
+package terraform
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiagnostics(t *testing.T) {
+	cases := []struct {
+		desc     string
+		output   string
+		expected []string
+		summary  string
+	}{
+		{
+			desc: "boxed diagnostics",
+			output: "\x1b[31m╷\x1b[0m\x1b[0m\n" +
+				"\x1b[31m│\x1b[0m \x1b[0m\x1b[1m\x1b[31mError: \x1b[0m\x1b[0m\x1b[1mUnsupported argument\x1b[0m\n" +
+				"\x1b[31m│\x1b[0m \x1b[0m\n" +
+				"\x1b[31m│\x1b[0m \x1b[0m\x1b[0m  on main.tf line 12, in resource \"aws_instance\" \"web\":\n" +
+				"\x1b[31m│\x1b[0m \x1b[0m  12:   foo = \"bar\"\n" +
+				"\x1b[31m│\x1b[0m \x1b[0m\n" +
+				"\x1b[31m│\x1b[0m \x1b[0mAn argument named \"foo\" is not expected here.\n" +
+				"\x1b[31m╵\x1b[0m\x1b[0m\n" +
+				"╷\n" +
+				"│ Warning: Argument is deprecated\n" +
+				"│ \n" +
+				"│   with aws_s3_bucket.logs,\n" +
+				"│   on s3.tf line 3, in resource \"aws_s3_bucket\" \"logs\":\n" +
+				"│    3:   acl    = \"private\"\n" +
+				"│    4:   policy = data.aws_iam_policy_document.logs.json\n" +
+				"│ \n" +
+				"│ Use the aws_s3_bucket_acl resource instead\n" +
+				"╵\n",
+			expected: []string{
+				"Error: Unsupported argument (main.tf:12)",
+				"Warning: Argument is deprecated (s3.tf:3-4)",
+			},
+			summary: `
+==== terraform diagnostics: 1 error, 1 warning ====
+Error: Unsupported argument (main.tf:12)
+Warning: Argument is deprecated (s3.tf:3-4)
+`,
+		},
+		{
+			desc: "repeated by the debug callback",
+			output: "fatal: [localhost]: FAILED! => {\n" +
+				"MSG:\n\nFailure when executing Terraform command. Exited 1.\nstdout: \nstderr: \n" +
+				"Error: Invalid reference\n\n  on variables.tf line 7:\n   7:   default = foo\n\nA reference to a resource type must be followed by at least one attribute access.\n" +
+				"STDERR:\n\n" +
+				"Error: Invalid reference\n\n  on variables.tf line 7:\n   7:   default = foo\n\nA reference to a resource type must be followed by at least one attribute access.\n" +
+				"Error: No valid credential sources found\n",
+			expected: []string{
+				"Error: Invalid reference (variables.tf:7)",
+				"Error: No valid credential sources found",
+			},
+			summary: `
+==== terraform diagnostics: 2 errors, 0 warnings ====
+Error: Invalid reference (variables.tf:7)
+Error: No valid credential sources found
+`,
+		},
+		{
+			desc:   "no diagnostics",
+			output: "Apply complete! Resources: 1 added, 0 changed, 0 destroyed.\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			w := newOutputWatcher(&bytes.Buffer{})
+			w.Write([]byte(c.output))
+			diags := mergeDiagnostics(w.Result().diagnostics)
+			var actual []string
+			for _, d := range diags {
+				actual = append(actual, d.String())
+			}
+			assert.Equal(t, c.expected, actual)
+
+			var buf bytes.Buffer
+			writeDiagnostics(&buf, diags)
+			assert.Equal(t, c.summary, buf.String())
+		})
+	}
+}
+
+func TestMergeDiagnostics(t *testing.T) {
+	warning := diagnostic{severity: severityWarning, summary: "Argument is deprecated", file: "s3.tf", start: 3, end: 4}
+	err := diagnostic{severity: severityError, summary: "Unsupported argument", file: "main.tf", start: 12, end: 12}
+
+	diags := mergeDiagnostics([]diagnostic{warning}, []diagnostic{warning, err})
+	assert.Equal(t, []diagnostic{err, warning}, diags)
+	assert.Equal(t, []types.Metadata{
+		{Name: "diagnostics", Value: "1 error, 1 warning"},
+		{Name: "diagnostics_summary", Value: "Error: Unsupported argument (main.tf:12)\nWarning: Argument is deprecated (s3.tf:3-4)"},
+	}, diagnosticsMetadata(diags))
+	assert.Nil(t, diagnosticsMetadata(nil))
+}
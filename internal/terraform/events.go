@@ -0,0 +1,157 @@
+package terraform
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// directory of the resource_events callback plugin, see
+// ansible/callback_plugins/resource_events.py
+const callbackPluginsDir = "/opt/ansible/callback_plugins"
+
+// ansible task statuses reported by the resource_events callback plugin
+const (
+	taskStatusFailed      = "failed"
+	taskStatusSkipped     = "skipped"
+	taskStatusUnreachable = "unreachable"
+)
+
+// taskEvent describes a task result written by the resource_events callback
+// plugin
+type taskEvent struct {
+	Event    string     `json:"event"`
+	Task     string     `json:"task"`
+	Action   string     `json:"action"`
+	Status   string     `json:"status"`
+	Ignored  bool       `json:"ignored"`
+	Duration float64    `json:"duration"`
+	Result   taskResult `json:"result"`
+}
+
+// taskResult describes the recorded fields of an ansible module result, the
+// results of looped tasks are recorded per item
+type taskResult struct {
+	Msg     string       `json:"msg"`
+	RC      *int         `json:"rc"`
+	Stdout  string       `json:"stdout"`
+	Stderr  string       `json:"stderr"`
+	Command string       `json:"command"`
+	Failed  bool         `json:"failed"`
+	Results []taskResult `json:"results"`
+}
+
+// readEvents reads the task events of a run, malformed lines are skipped
+func readEvents(r io.Reader) ([]taskEvent, error) {
+	var events []taskEvent
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e taskEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Event != "task" {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+// readEventsFile reads the task events written to f, if any
+func readEventsFile(f string) []taskEvent {
+	file, err := os.Open(f)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	events, _ := readEvents(file)
+	return events
+}
+
+// failedTask returns the first failed task whose errors were not ignored
+func failedTask(events []taskEvent) *taskEvent {
+	for i, e := range events {
+		if (e.Status == taskStatusFailed && !e.Ignored) || e.Status == taskStatusUnreachable {
+			return &events[i]
+		}
+	}
+	return nil
+}
+
+// phase returns the terraform phase of a task, eg. "terraform apply" for the
+// "run terraform apply" task
+func (e *taskEvent) phase() string {
+	return strings.TrimPrefix(e.Task, "run ")
+}
+
+// diagnostic returns the first terraform error diagnostic of a failed task,
+// falling back to the diagnostic of its first failed item for looped tasks and
+// then to the first line of the module's failure message
+func (e *taskEvent) diagnostic() string {
+	if d := e.Result.diagnostic(); d != "" {
+		return d
+	}
+	return e.Status
+}
+
+// diagnostic returns the diagnostic of a module result, if any
+func (r *taskResult) diagnostic() string {
+	for _, out := range []string{r.Stderr, r.Msg, r.Stdout} {
+		for _, line := range strings.Split(out, "\n") {
+			line = strings.TrimSpace(strings.TrimLeft(ansiPattern.ReplaceAllString(line, ""), "│╷╵ "))
+			if strings.HasPrefix(line, "Error: ") {
+				return strings.TrimPrefix(line, "Error: ")
+			}
+		}
+	}
+	for i := range r.Results {
+		if r.Results[i].Failed {
+			if d := r.Results[i].diagnostic(); d != "" {
+				return d
+			}
+		}
+	}
+	if msg := strings.TrimSpace(r.Msg); msg != "" {
+		return strings.SplitN(msg, "\n", 2)[0]
+	}
+	if r.RC != nil {
+		return fmt.Sprintf("exit status %d", *r.RC)
+	}
+	return ""
+}
+
+// err returns the error of a failed task
+func (e *taskEvent) err() error {
+	return fmt.Errorf("%s failed: %s", e.phase(), e.diagnostic())
+}
+
+// writeTimings writes a table of the duration and status of every task that
+// was not skipped
+func writeTimings(w io.Writer, workspace string, events []taskEvent) {
+	fmt.Fprintf(w, "\n==== task timings (workspace: %s) ====\n", workspace)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TASK\tSTATUS\tDURATION")
+	var total float64
+	for _, e := range events {
+		if e.Status == taskStatusSkipped {
+			continue
+		}
+		status := e.Status
+		if e.Ignored {
+			status += " (ignored)"
+		}
+		total += e.Duration
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", e.Task, status, seconds(e.Duration))
+	}
+	fmt.Fprintf(tw, "total\t\t%s\n", seconds(total))
+	tw.Flush()
+}
+
+// seconds formats a duration in seconds
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(100 * time.Millisecond)
+}
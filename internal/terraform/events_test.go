@@ -0,0 +1,93 @@
+package terraform
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskEvents(t *testing.T) {
+	cases := []struct {
+		desc    string
+		events  string
+		failed  string
+		err     string
+		timings string
+	}{
+		{
+			desc: "success",
+			events: `{"event": "task", "task": "write resource variables to file", "action": "copy", "status": "ok", "duration": 0.21, "result": {"changed": true}}
+{"event": "task", "task": "run terraform init from cache", "action": "command", "status": "skipped", "duration": 0.01, "result": {}}
+{"event": "task", "task": "run terraform plan", "action": "community.general.terraform", "status": "ok", "duration": 62.04, "result": {"stdout": "No changes."}}
+`,
+			timings: `
+==== task timings (workspace: foo) ====
+TASK                              STATUS  DURATION
+write resource variables to file  ok      200ms
+run terraform plan                ok      1m2s
+total                                     1m2.3s
+`,
+		},
+		{
+			desc: "terraform apply failure",
+			events: `{"event": "task", "task": "run terraform plan", "action": "community.general.terraform", "status": "ok", "duration": 12.5, "result": {}}
+not json
+{"event": "task", "task": "run terraform apply", "action": "community.general.terraform", "status": "failed", "duration": 30, "result": {"rc": 1, "msg": "Failure when executing Terraform command. Exited 1.\nstdout: \nstderr: \u001b[31m╷\u001b[0m\u001b[0m\n\u001b[31m│\u001b[0m \u001b[0m\u001b[1m\u001b[31mError: \u001b[0m\u001b[0m\u001b[1mcreating EC2 Instance: UnauthorizedOperation\u001b[0m\n\u001b[31m│\u001b[0m \u001b[0m\n\u001b[31m│\u001b[0m \u001b[0m  with aws_instance.web,\n", "stderr": ""}}
+{"event": "task", "task": "run on_failure hooks", "action": "shell", "status": "failed", "ignored": true, "duration": 1, "result": {"rc": 1}}
+{"event": "task", "task": "terraform failed", "action": "fail", "status": "failed", "duration": 0, "result": {"msg": "run terraform apply failed"}}
+`,
+			failed: "run terraform apply",
+			err:    "terraform apply failed: creating EC2 Instance: UnauthorizedOperation",
+			timings: `
+==== task timings (workspace: foo) ====
+TASK                  STATUS            DURATION
+run terraform plan    ok                12.5s
+run terraform apply   failed            30s
+run on_failure hooks  failed (ignored)  1s
+terraform failed      failed            0s
+total                                   43.5s
+`,
+		},
+		{
+			desc:   "hook failure without diagnostic",
+			events: `{"event": "task", "task": "run post_apply hooks", "action": "shell", "status": "failed", "duration": 2, "result": {"msg": "One or more items failed", "results": [{"rc": 0, "stderr": ""}, {"rc": 3, "stderr": "smoke test failed", "failed": true}]}}` + "\n",
+			failed: "run post_apply hooks",
+			err:    "post_apply hooks failed: exit status 3",
+		},
+		{
+			desc:   "hook failure with diagnostic",
+			events: `{"event": "task", "task": "run post_apply hooks", "action": "shell", "status": "failed", "duration": 2, "result": {"msg": "One or more items failed", "results": [{"rc": 1, "stderr": "Error: state lock not released", "msg": "non-zero return code", "failed": true}]}}` + "\n",
+			failed: "run post_apply hooks",
+			err:    "post_apply hooks failed: state lock not released",
+		},
+		{
+			desc:   "module failure message",
+			events: `{"event": "task", "task": "fetch terraform backend metadata", "action": "set_fact", "status": "failed", "duration": 1, "result": {"msg": "permission denied\nmore details"}}` + "\n",
+			failed: "fetch terraform backend metadata",
+			err:    "fetch terraform backend metadata failed: permission denied",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			events, err := readEvents(strings.NewReader(c.events))
+			assert.NoError(t, err)
+
+			failed := failedTask(events)
+			if c.failed == "" {
+				assert.Nil(t, failed)
+			} else if assert.NotNil(t, failed) {
+				assert.Equal(t, c.failed, failed.Task)
+				assert.EqualError(t, failed.err(), c.err)
+			}
+
+			if c.timings != "" {
+				var buf bytes.Buffer
+				writeTimings(&buf, "foo", events)
+				assert.Equal(t, c.timings, buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package terraform
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+)
+
+// run describes a single ansible-playbook invocation within a fan-out put
+type run struct {
+	name     string
+	context  string
+	dir      string
+	ansible  *Ansible
+	deps     []string
+	duration time.Duration
+	err      error
+	skipped  bool
+
+	// prepare, if set, builds the ansible-playbook command once all
+	// dependencies have succeeded
+	prepare func() error
+}
+
+// status returns a human readable run status
+func (r *run) status() string {
+	switch {
+	case r.skipped:
+		return "skipped"
+	case r.err != nil:
+		return "failed"
+	}
+	return "succeeded"
+}
+
+// runner executes fan-out runs with bounded parallelism in dependency order,
+// printing a log section per run and a final summary table
+type runner struct {
+	ctx         context.Context
+	label       string
+	parallelism int
+	failFast    bool
+	stderr      io.Writer
+	mu          sync.Mutex
+}
+
+// newRunner instantiates a new runner, parallelism defaults to 1
+func newRunner(ctx context.Context, stderr io.Writer, parallelism int, label string, failFast bool) *runner {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	return &runner{
+		ctx:         ctx,
+		label:       label,
+		parallelism: parallelism,
+		failFast:    failFast,
+		stderr:      stderr,
+	}
+}
+
+// execute runs all runs and returns an error if any of them failed, runs are
+// started once all of their dependencies have succeeded and skipped if any
+// dependency failed, or any run failed in fail fast mode, or the put was
+// interrupted
+func (r *runner) execute(runs []*run) error {
+	done := make(chan *run)
+	finished := map[string]*run{}
+	pending := runs
+	running := 0
+	failed := false
+
+	for len(pending) > 0 || running > 0 {
+		progressed := false
+		var next []*run
+		for _, rn := range pending {
+			ready, blocked := r.dependencyState(rn, finished)
+			switch {
+			case r.ctx.Err() != nil:
+				rn.skipped = true
+				finished[rn.name] = rn
+				progressed = true
+				r.logf("==== %s skipped, interrupted ====\n", rn.name)
+			case failed && r.failFast:
+				rn.skipped = true
+				finished[rn.name] = rn
+				progressed = true
+				r.logf("==== %s skipped, fail fast ====\n", rn.name)
+			case blocked:
+				rn.skipped = true
+				finished[rn.name] = rn
+				progressed = true
+				r.logf("==== %s skipped, dependency did not succeed ====\n", rn.name)
+			case ready && running < r.parallelism:
+				progressed = true
+				if rn.prepare != nil {
+					if err := rn.prepare(); err != nil {
+						rn.err = err
+						failed = true
+						finished[rn.name] = rn
+						r.logf("==== %s failed: %v ====\n", rn.name, err)
+						continue
+					}
+				}
+				running++
+				go func(rn *run) {
+					r.executeRun(rn)
+					done <- rn
+				}(rn)
+			default:
+				next = append(next, rn)
+			}
+		}
+		pending = next
+
+		if running == 0 {
+			if progressed {
+				continue
+			}
+			// remaining runs can never become ready
+			for _, rn := range pending {
+				rn.skipped = true
+			}
+			break
+		}
+		rn := <-done
+		running--
+		finished[rn.name] = rn
+		failed = failed || rn.err != nil
+	}
+
+	r.summary(runs)
+
+	var unsuccessful []string
+	for _, rn := range runs {
+		if rn.err != nil || rn.skipped {
+			unsuccessful = append(unsuccessful, rn.name)
+		}
+	}
+	if len(unsuccessful) > 0 {
+		return fmt.Errorf("error executing ansible-playbook for: %s", strings.Join(unsuccessful, ", "))
+	}
+	return nil
+}
+
+// dependencyState reports whether all dependencies of rn succeeded, or if any
+// of them failed or was skipped
+func (r *runner) dependencyState(rn *run, finished map[string]*run) (ready bool, blocked bool) {
+	ready = true
+	for _, dep := range rn.deps {
+		d, ok := finished[dep]
+		if !ok {
+			ready = false
+			continue
+		}
+		if d.err != nil || d.skipped {
+			return false, true
+		}
+	}
+	return ready, false
+}
+
+// executeRun executes a single run, output is streamed when runs are executed
+// sequentially and buffered into a single log section otherwise
+func (r *runner) executeRun(rn *run) {
+	header := fmt.Sprintf("\n==== %s (context: %s) ====\n", rn.name, rn.context)
+	var buf bytes.Buffer
+	if r.parallelism == 1 {
+		r.logf("%s", header)
+		rn.ansible.stdout = r.stderr
+	} else {
+		rn.ansible.stdout = &buf
+	}
+
+	start := time.Now()
+	rn.err = rn.ansible.Run(r.ctx)
+	rn.duration = time.Since(start).Round(time.Second)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.parallelism > 1 {
+		fmt.Fprint(r.stderr, header)
+		r.stderr.Write(buf.Bytes())
+	}
+	if rn.err != nil {
+		fmt.Fprintf(r.stderr, "==== %s failed after %s: %v ====\n", rn.name, rn.duration, rn.err)
+	} else {
+		fmt.Fprintf(r.stderr, "==== %s succeeded after %s ====\n", rn.name, rn.duration)
+	}
+}
+
+// logf writes a log line without interleaving with buffered run output
+func (r *runner) logf(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(r.stderr, format, args...)
+}
+
+// summary prints a table of run results
+func (r *runner) summary(runs []*run) {
+	fmt.Fprintln(r.stderr)
+	w := tabwriter.NewWriter(r.stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tCONTEXT\tSTATUS\tDURATION\n", strings.ToUpper(r.label))
+	for _, rn := range runs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", rn.name, rn.context, rn.status(), rn.duration)
+	}
+	w.Flush()
+}
+
+// runMetadata returns put metadata describing run results
+func runMetadata(runs []*run) []types.Metadata {
+	metadata := make([]types.Metadata, len(runs))
+	for i, rn := range runs {
+		metadata[i] = types.Metadata{
+			Name:  rn.name,
+			Value: fmt.Sprintf("%s (%s)", rn.status(), rn.duration),
+		}
+	}
+	return metadata
+}
+
+// isolate configures a fan-out run to write into dir, relative to the put
+// working directory. The plugin cache is not safe for concurrent use, so runs
+// executing in parallel use their own plugin cache dir within it
+func (cmd *Out) isolate(ansible *Ansible, req *types.OutRequest, dir string) error {
+	ansible.isolate(path.Join(cmd.args[1], dir))
+	if cmd.pluginCacheDir == "" || req.Params.Parallelism < 2 {
+		return nil
+	}
+	cacheDir := path.Join(cmd.pluginCacheDir, dir)
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("error creating plugin cache dir: %v", err)
+	}
+	ansible.envs = append(ansible.envs, fmt.Sprintf("TF_PLUGIN_CACHE_DIR=%s", cacheDir))
+	return nil
+}
+
+// runDiagnostics returns the distinct terraform diagnostics of all runs
+func runDiagnostics(runs []*run) []diagnostic {
+	var diags [][]diagnostic
+	for _, rn := range runs {
+		if rn.ansible != nil {
+			diags = append(diags, rn.ansible.diagnostics)
+		}
+	}
+	return mergeDiagnostics(diags...)
+}
+
+// runVersionFiles returns the version files written by each run, relative to the
+// put working directory
+func runVersionFiles(runs []*run) []string {
+	var files []string
+	for _, rn := range runs {
+		for _, f := range versionFiles {
+			files = append(files, path.Join(rn.dir, f))
+		}
+	}
+	return files
+}
+
+// prepare the runs of a multi-workspace or stack put, returning the label
+// used to describe them
+func (cmd *Out) fanOutRuns(req *types.OutRequest) ([]*run, string, error) {
+	if req.Params.Stack != "" {
+		runs, err := cmd.stackRuns(req)
+		return runs, "module", err
+	}
+	if len(req.Params.Regions) > 0 {
+		runs, err := cmd.regionRuns(req)
+		return runs, "workspace", err
+	}
+	runs, err := cmd.workspaceRuns(req)
+	return runs, "workspace", err
+}
+
+// resolve the workspace entries of a multi-workspace put
+func (cmd *Out) workspaceEntries(req *types.OutRequest) ([]types.WorkspaceEntry, error) {
+	if req.Params.Workspaces.Mapping == "" {
+		return req.Params.Workspaces.Entries, nil
+	}
+	msg, err := cmd.parseMapping(req.Params.Workspaces.Mapping)
+	if err != nil {
+		return nil, err
+	}
+	var entries []types.WorkspaceEntry
+	if err := json.Unmarshal(msg.Get(0).Get(), &entries); err != nil {
+		return nil, fmt.Errorf("error parsing mapped workspaces: %v", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("workspaces mapping must evaluate to a non-empty array")
+	}
+	return entries, nil
+}
+
+// prepare one ansible-playbook command per workspace entry
+func (cmd *Out) workspaceRuns(req *types.OutRequest) ([]*run, error) {
+	if err := cmd.parseInput(req); err != nil {
+		return nil, err
+	}
+	entries, err := cmd.workspaceEntries(req)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing workspaces: %v", err)
+	}
+	return cmd.entryRuns(req, entries)
+}
+
+// prepare one isolated ansible-playbook command per workspace entry
+func (cmd *Out) entryRuns(req *types.OutRequest, entries []types.WorkspaceEntry) ([]*run, error) {
+	runs := make([]*run, 0, len(entries))
+	seen := map[string]bool{}
+	for i, entry := range entries {
+		r := *req
+		if entry.Context != "" {
+			r.Params.Context = entry.Context
+		}
+		r.Params.Workspace = entry.Workspace
+
+		ansible, err := cmd.ansiblePlaybookCmd(&r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid workspace entry (%d): %v", i, err)
+		}
+		workspace := ansible.extraVars.Path("terraform_workspace").Data().(string)
+		if workspace == "" || strings.ContainsAny(workspace, `/\`) || workspace == "." || workspace == ".." {
+			return nil, fmt.Errorf("invalid workspace entry (%d): invalid workspace (%s)", i, workspace)
+		}
+		if seen[workspace] {
+			return nil, fmt.Errorf("invalid workspace entry (%d): duplicate workspace (%s)", i, workspace)
+		}
+		seen[workspace] = true
+		context := ansible.extraVars.Path("context").Data().(string)
+		if context == "" {
+			return nil, fmt.Errorf("invalid workspace entry (%d): missing context", i)
+		}
+
+		for k, v := range entry.Vars {
+			ansible.extraVars.Set(v, "terraform_vars", k)
+		}
+
+		rn := &run{
+			name:    workspace,
+			context: context,
+			dir:     path.Join("workspaces", workspace),
+			ansible: ansible,
+		}
+		if err := cmd.isolate(ansible, req, rn.dir); err != nil {
+			return nil, err
+		}
+		runs = append(runs, rn)
+	}
+	return runs, nil
+}
@@ -0,0 +1,190 @@
+package terraform
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestWorkspaceRuns(t *testing.T) {
+	src := `"source": {
+		"storage": {"aws_access_key_id": "foo", "aws_secret_access_key": "bar", "bucket": "foo", "region": "us-east-1"},
+		"vault": {"addr": "https://vault.com", "role_id": "vault-role-id", "secret_id": "vault-secret-id"}
+	}`
+
+	cases := []struct {
+		desc    string
+		payload string
+		assert  func([]*run, error)
+	}{
+		{
+			desc: "list",
+			payload: `{` + src + `, "params": {
+				"dir": "source/terraform",
+				"context": "qa1",
+				"vars_mapping": "root = {\"region\": \"us-east-1\", \"size\": 1}",
+				"workspaces": [
+					{"workspace": "qa1-use1"},
+					{"workspace": "qa1-use2", "context": "qa1-use2", "vars": {"region": "us-east-2"}}
+				]
+			}}`,
+			assert: func(runs []*run, err error) {
+				assert.NoError(t, err)
+				assert.Len(t, runs, 2)
+
+				assert.Equal(t, "qa1-use1", runs[0].name)
+				assert.Equal(t, "qa1", runs[0].context)
+				assert.Equal(t, "workspaces/qa1-use1", runs[0].dir)
+				assert.Contains(t, runs[0].ansible.envs, "TF_DATA_DIR=/tmp/build/put/workspaces/qa1-use1/.terraform")
+
+				vars := extraVars(t, runs[1].ansible)
+				assert.Equal(t, "qa1-use2", gjson.GetBytes(vars, "context").String())
+				assert.Equal(t, "qa1-use2", gjson.GetBytes(vars, "terraform_workspace").String())
+				assert.Equal(t, "us-east-2", gjson.GetBytes(vars, "terraform_vars.region").String())
+				assert.Equal(t, int64(1), gjson.GetBytes(vars, "terraform_vars.size").Int())
+				assert.Equal(t, "/tmp/build/put/workspaces/qa1-use2", gjson.GetBytes(vars, "terraform_run_dir").String())
+				assert.Equal(t, "/tmp/build/put/workspaces/qa1-use2", gjson.GetBytes(vars, "terraform_output_dir").String())
+
+				assert.Equal(t, []string{
+					"workspaces/qa1-use1/outputs.json",
+					"workspaces/qa1-use1/workspace.txt",
+					"workspaces/qa1-use2/outputs.json",
+					"workspaces/qa1-use2/workspace.txt",
+				}, runVersionFiles(runs))
+			},
+		},
+		{
+			desc: "mapping",
+			payload: `{` + src + `, "params": {
+				"dir": "source/terraform",
+				"input_mapping": "contexts = [\"qa1-use1\", \"qa1-use2\", \"qa1-usw2\"]",
+				"workspaces": "root = this.contexts.map_each(c -> {\"context\": c, \"workspace\": c + \"-monitoring\"})"
+			}}`,
+			assert: func(runs []*run, err error) {
+				assert.NoError(t, err)
+				assert.Len(t, runs, 3)
+				assert.Equal(t, "qa1-usw2-monitoring", runs[2].name)
+				assert.Equal(t, "qa1-usw2", runs[2].context)
+			},
+		},
+		{
+			desc: "missing context",
+			payload: `{` + src + `, "params": {
+				"dir": "source/terraform",
+				"workspaces": [{"workspace": "qa1-use1"}]
+			}}`,
+			assert: func(runs []*run, err error) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			desc: "dot workspace",
+			payload: `{` + src + `, "params": {
+				"dir": "source/terraform",
+				"workspaces": [{"context": "qa1-use1", "workspace": "."}]
+			}}`,
+			assert: func(runs []*run, err error) {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), "invalid workspace (.)")
+				}
+			},
+		},
+		{
+			desc: "terragrunt",
+			payload: `{"source": {
+				"engine": "terragrunt",
+				"storage": {"aws_access_key_id": "foo", "aws_secret_access_key": "bar", "bucket": "foo", "region": "us-east-1"},
+				"vault": {"addr": "https://vault.com", "role_id": "vault-role-id", "secret_id": "vault-secret-id"}
+			}, "params": {
+				"dir": "source/terragrunt",
+				"workspaces": [{"context": "qa1-use1"}, {"context": "qa1-use2"}]
+			}}`,
+			assert: func(runs []*run, err error) {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), "workspaces and regions are not supported by the terragrunt engine")
+				}
+			},
+		},
+		{
+			desc: "duplicate workspace",
+			payload: `{` + src + `, "params": {
+				"dir": "source/terraform",
+				"workspaces": [{"context": "qa1-use1"}, {"context": "qa1-use1"}]
+			}}`,
+			assert: func(runs []*run, err error) {
+				assert.Error(t, err)
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			var req types.OutRequest
+			assert.NoError(t, json.Unmarshal([]byte(c.payload), &req))
+			out := &Out{
+				args: []string{"/out", "/tmp/build/put"},
+				env: types.Environment{
+					ID:             "2199",
+					Job:            "testing",
+					Name:           "217",
+					Pipeline:       "example-component",
+					Team:           "sre",
+					ATCExternalURL: "http://127.0.0.1:8080",
+				},
+			}
+			runs, err := out.workspaceRuns(&req)
+			c.assert(runs, err)
+		})
+	}
+}
+
+// extraVars renders the extra vars of an ansible-playbook command
+func extraVars(t *testing.T, ansible *Ansible) []byte {
+	f, err := ansible.prepareRun()
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	vars, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+	return vars
+}
+
+func TestIsolatePluginCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	cases := []struct {
+		desc        string
+		parallelism int
+		expected    string
+	}{
+		{desc: "sequential runs share the plugin cache", parallelism: 1},
+		{desc: "parallel runs use their own plugin cache", parallelism: 2, expected: "TF_PLUGIN_CACHE_DIR=" + cacheDir + "/workspaces/qa1-use1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			out := &Out{args: []string{"/out", "/tmp/build/put"}, pluginCacheDir: cacheDir}
+			ansible := NewAnsible(&types.Source{}, ioutil.Discard, &types.Environment{}, "/opt/ansible/out.yml", "/tmp/build/put")
+			req := &types.OutRequest{Params: types.OutParams{Parallelism: c.parallelism}}
+			assert.NoError(t, out.isolate(ansible, req, "workspaces/qa1-use1"))
+
+			var envs []string
+			for _, e := range ansible.envs {
+				if strings.HasPrefix(e, "TF_PLUGIN_CACHE_DIR=") {
+					envs = append(envs, e)
+				}
+			}
+			if c.expected == "" {
+				assert.Empty(t, envs)
+				return
+			}
+			assert.Equal(t, []string{c.expected}, envs)
+			assert.DirExists(t, path.Join(cacheDir, "workspaces/qa1-use1"))
+		})
+	}
+}
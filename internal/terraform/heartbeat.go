@@ -0,0 +1,73 @@
+package terraform
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// activityWriter passes output through to w while recording the time of the
+// most recent write
+type activityWriter struct {
+	w    io.Writer
+	mu   sync.Mutex
+	last time.Time
+}
+
+// newActivityWriter instantiates a new activityWriter writing to w
+func newActivityWriter(w io.Writer) *activityWriter {
+	return &activityWriter{w: w, last: time.Now()}
+}
+
+// Write implements io.Writer
+func (a *activityWriter) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	a.last = time.Now()
+	a.mu.Unlock()
+	return a.w.Write(p)
+}
+
+// quiet returns the time elapsed since the most recent write
+func (a *activityWriter) quiet() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return time.Since(a.last)
+}
+
+// startHeartbeat logs the elapsed time whenever the output of a has been quiet
+// for interval, until the returned function is called
+func startHeartbeat(a *activityWriter, interval time.Duration, workspace string) func() {
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	start := time.Now()
+	go func() {
+		defer close(stopped)
+		// tickers panic on non-positive periods
+		period := interval / 2
+		if period <= 0 {
+			period = time.Millisecond
+		}
+		tick := time.NewTicker(period)
+		defer tick.Stop()
+		logged := start
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-tick.C:
+				quiet := a.quiet()
+				if quiet < interval || now.Sub(logged) < interval {
+					continue
+				}
+				logged = now
+				logrus.Infof("terraform still running for workspace (%s), elapsed %s, no output for %s",
+					workspace, now.Sub(start).Round(time.Second), quiet.Round(time.Second))
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
@@ -0,0 +1,53 @@
+package terraform
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeartbeat(t *testing.T) {
+	cases := []struct {
+		desc   string
+		writes bool
+		logged bool
+	}{
+		{desc: "quiet", logged: true},
+		{desc: "active", writes: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+			logrus.SetOutput(&logs)
+			defer logrus.SetOutput(os.Stderr)
+
+			a := newActivityWriter(ioutil.Discard)
+			stop := startHeartbeat(a, 200*time.Millisecond, "qa1")
+			for i := 0; i < 10; i++ {
+				if c.writes {
+					a.Write([]byte("."))
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+			stop()
+
+			if c.logged {
+				assert.Contains(t, logs.String(), "terraform still running for workspace (qa1)")
+			} else {
+				assert.Empty(t, logs.String())
+			}
+		})
+	}
+}
+
+func TestHeartbeatShortInterval(t *testing.T) {
+	stop := startHeartbeat(newActivityWriter(ioutil.Discard), time.Nanosecond, "qa1")
+	time.Sleep(10 * time.Millisecond)
+	stop()
+}
@@ -1,11 +1,15 @@
 package terraform
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"time"
 
+	"github.com/adnankobir/concourse-terraform-resource/internal/storage"
 	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/sirupsen/logrus"
 )
 
 // In describes an in command executor
@@ -41,6 +45,19 @@ func (cmd *In) Execute() error {
 		return fmt.Errorf("invalid payload: %v", err)
 	}
 
+	if err := req.Validate(); err != nil {
+		return fmt.Errorf("invalid get request: %v", err)
+	}
+
+	req.Source.AssignFallbackValues(cmd.env.Team, cmd.env.Pipeline)
+
+	// download and extract version files, legacy versions have no archive
+	if legacyVersion(&req.Source.Storage, &req.Version) {
+		logrus.Warnf("version (%s) predates version archives, no version files fetched", req.Version.VersionID)
+	} else if err := cmd.getVersion(&req.Source, &req.Version); err != nil {
+		return fmt.Errorf("error fetching version: %v", err)
+	}
+
 	resp := types.InResponse{
 		Version:  req.Version,
 		Metadata: []types.Metadata{},
@@ -53,13 +70,27 @@ func (cmd *In) Execute() error {
 	return nil
 }
 
-func (cmd *In) ansiblePlaybookCmd(req *types.InRequest) (*Ansible, error) {
-	// validate put request
-	if err := req.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid get request: %v", err)
+// download version archive from storage and extract it into the working directory
+func (cmd *In) getVersion(src *types.Source, version *types.Version) error {
+	store, err := storage.New(&src.Storage, &src.Vault)
+	if err != nil {
+		return err
+	}
+
+	var archive bytes.Buffer
+	if err := store.Get(version.Key, version.VersionID, &archive); err != nil {
+		return err
 	}
+	return extractArchive(&archive, cmd.args[1])
+}
 
-	ansible := NewAnsible(&req.Source, cmd.stderr, &cmd.env, "/opt/ansible/in.yml", cmd.args[1])
-	ansible.extraVars.Set(req.Version, "version")
-	return ansible, nil
+// legacyVersion returns true for versions emitted before version archives were
+// stored, whose version id is the timestamp of the put. Only s3 storage was
+// supported then, azure version ids are timestamps as well
+func legacyVersion(cfg *types.Storage, version *types.Version) bool {
+	if cfg.StorageType() != types.StorageTypeS3 {
+		return false
+	}
+	_, err := time.Parse(time.RFC3339Nano, version.VersionID)
+	return err == nil
 }
@@ -0,0 +1,138 @@
+package terraform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIn(t *testing.T) {
+	for k, v := range map[string]string{
+		"BUILD_ID":            "2199",
+		"BUILD_NAME":          "217",
+		"BUILD_JOB_NAME":      "testing",
+		"BUILD_PIPELINE_NAME": "example-component",
+		"BUILD_TEAM_NAME":     "sre",
+		"ATC_EXTERNAL_URL":    "http://127.0.0.1:8080",
+	} {
+		t.Setenv(k, v)
+	}
+
+	storageDir, err := ioutil.TempDir("", "storage")
+	assert.NoError(t, err)
+	defer os.RemoveAll(storageDir)
+
+	src := types.Source{
+		Storage: types.Storage{
+			Type: types.StorageTypeLocal,
+			Path: storageDir,
+		},
+		Vault: types.VaultSource{
+			Addr:     "https://vault.com",
+			RoleID:   "vault-role-d",
+			SecretID: "vault-secret-id",
+		},
+	}
+
+	// store a version archive
+	putDir, err := ioutil.TempDir("", "put")
+	assert.NoError(t, err)
+	defer os.RemoveAll(putDir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(putDir, "outputs.json"), []byte(`{"foo":{"value":"bar"}}`), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(putDir, "workspace.txt"), []byte("qa1-use2"), 0644))
+	out := &Out{args: []string{"/out", putDir}}
+	putSrc := src
+	putSrc.Storage.Key = "sre/example-component/version.tgz"
+	version, err := out.putVersion(&putSrc, versionFiles)
+	assert.NoError(t, err)
+
+	cases := []struct {
+		desc    string
+		payload interface{}
+		assert  func(dir string, res *types.InResponse, err error)
+	}{
+		{
+			desc:    "invalid request",
+			payload: types.InRequest{Source: src},
+			assert: func(dir string, res *types.InResponse, err error) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			desc:    "missing version",
+			payload: types.InRequest{Source: src, Version: types.Version{Key: version.Key, VersionID: "missing"}},
+			assert: func(dir string, res *types.InResponse, err error) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			desc: "legacy version",
+			payload: types.InRequest{
+				Source: types.Source{
+					Storage: types.Storage{
+						AWSAccessKeyID:     "foo",
+						AWSSecretAccessKey: "bar",
+						Bucket:             "foo",
+						Key:                "sre/example-component/version.tgz",
+						Region:             "us-east-1",
+					},
+					Vault: src.Vault,
+				},
+				Version: types.Version{Key: "sre/example-component/version.tgz", VersionID: "2022-10-12T09:41:07.123456789Z"},
+			},
+			assert: func(dir string, res *types.InResponse, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, "2022-10-12T09:41:07.123456789Z", res.Version.VersionID)
+				_, err = os.Stat(filepath.Join(dir, "outputs.json"))
+				assert.True(t, os.IsNotExist(err))
+			},
+		},
+		{
+			desc:    "version",
+			payload: types.InRequest{Source: src, Version: version},
+			assert: func(dir string, res *types.InResponse, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, version, res.Version)
+
+				outputs, err := ioutil.ReadFile(filepath.Join(dir, "outputs.json"))
+				assert.NoError(t, err)
+				assert.JSONEq(t, `{"foo":{"value":"bar"}}`, string(outputs))
+				workspace, err := ioutil.ReadFile(filepath.Join(dir, "workspace.txt"))
+				assert.NoError(t, err)
+				assert.Equal(t, "qa1-use2", string(workspace))
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "get")
+			assert.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			payload, err := json.Marshal(c.payload)
+			assert.NoError(t, err)
+
+			stderr := &bytes.Buffer{}
+			stdout := &bytes.Buffer{}
+			rerr := NewIn(bytes.NewBuffer(payload), stderr, stdout, []string{"in", dir}).Execute()
+			if rerr != nil {
+				c.assert(dir, nil, rerr)
+				return
+			}
+			var res types.InResponse
+			if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+				c.assert(dir, nil, fmt.Errorf("invalid response: %v", err))
+				return
+			}
+			c.assert(dir, &res, nil)
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package terraform
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// directories of the terraform data dir persisted in the init cache
+var initCacheDirs = []string{"providers", "modules"}
+
+// matches module and provider source and version arguments
+var sourcePattern = regexp.MustCompile(`^\s*(source|version)\s*=\s*(.+?)\s*$`)
+
+// initCache persists the providers and modules of terraform data dirs in
+// storage, keyed by a hash of the dependency lock file and the module sources
+// of the module and the local modules it calls.
+// Entries are content addressed, concurrent builds writing the same entry
+// store identical archives.
+type initCache struct {
+	store  storage.Storage
+	prefix string
+}
+
+// key returns the cache key of a terraform module, modules without a
+// dependency lock file are not cached
+func (c *initCache) key(moduleDir string) (string, error) {
+	lock, err := ioutil.ReadFile(path.Join(moduleDir, ".terraform.lock.hcl"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write(lock)
+	if err := hashModule(h, moduleDir, path.Clean(moduleDir), map[string]bool{}); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s.tgz", c.prefix, hex.EncodeToString(h.Sum(nil))), nil
+}
+
+// write the module and provider source arguments of a module and the local
+// modules it calls to w, as terraform does not reinstall changed modules when
+// initialized from the cache
+func hashModule(w io.Writer, root, dir string, seen map[string]bool) error {
+	if seen[dir] {
+		return nil
+	}
+	seen[dir] = true
+	files, err := filepath.Glob(path.Join(dir, "*.tf"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	var local []string
+	for _, f := range files {
+		name, err := filepath.Rel(root, f)
+		if err != nil {
+			return err
+		}
+		sources, err := hashSources(w, name, f)
+		if err != nil {
+			return err
+		}
+		local = append(local, sources...)
+	}
+	for _, source := range local {
+		if err := hashModule(w, root, path.Join(dir, source), seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write the module and provider source arguments of a terraform file to w,
+// returning the local module sources it calls
+func hashSources(w io.Writer, name, file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var local []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := sourcePattern.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		fmt.Fprintf(w, "%s:%s=%s\n", name, m[1], m[2])
+		if source := strings.Trim(m[2], `"`); m[1] == "source" && (strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../")) {
+			local = append(local, source)
+		}
+	}
+	return local, scanner.Err()
+}
+
+// restore extracts the cache entry at key into dataDir, returning false on a
+// cache miss. Entries are downloaded to a temporary file as providers may be
+// hundreds of MB.
+func (c *initCache) restore(key, dataDir string) bool {
+	archive, err := ioutil.TempFile("", "initcache")
+	if err != nil {
+		logrus.Warnf("error restoring init cache: %v", err)
+		return false
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	if err := c.store.Get(key, "", archive); err != nil {
+		logrus.Infof("init cache miss (%s)", key)
+		logrus.Debugf("error getting init cache: %v", err)
+		return false
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		logrus.Warnf("error restoring init cache: %v", err)
+		return false
+	}
+	for _, dir := range initCacheDirs {
+		if err := os.RemoveAll(path.Join(dataDir, dir)); err != nil {
+			logrus.Warnf("error restoring init cache: %v", err)
+			return false
+		}
+	}
+	if err := extractArchive(archive, dataDir); err != nil {
+		logrus.Warnf("error restoring init cache: %v", err)
+		return false
+	}
+	logrus.Infof("init cache hit (%s)", key)
+	return true
+}
+
+// save stores the providers and modules of dataDir at key, the archive is
+// written to a temporary file before it is uploaded
+func (c *initCache) save(key, dataDir string) error {
+	var files []string
+	for _, dir := range initCacheDirs {
+		if err := collectFiles(dataDir, dir, &files); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	archive, err := ioutil.TempFile("", "initcache")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	if err := createArchive(archive, dataDir, files); err != nil {
+		return err
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := c.store.Put(key, archive); err != nil {
+		return err
+	}
+	logrus.Infof("init cache saved (%s)", key)
+	return nil
+}
+
+// collect the regular files below root/name, following symlinks created when
+// a plugin cache dir is used
+func collectFiles(root, name string, files *[]string) error {
+	info, err := os.Stat(path.Join(root, name))
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		if info.Mode().IsRegular() {
+			*files = append(*files, name)
+		}
+		return nil
+	}
+	entries, err := ioutil.ReadDir(path.Join(root, name))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := collectFiles(root, path.Join(name, e.Name()), files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package terraform
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/storage"
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitCache(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.New(&types.Storage{Type: types.StorageTypeLocal, Path: path.Join(dir, "storage")}, nil)
+	assert.NoError(t, err)
+	cache := &initCache{store: store, prefix: "sre/concourse-terraform-resource/init-cache"}
+
+	write := func(name, content string) {
+		assert.NoError(t, os.MkdirAll(path.Dir(name), 0755))
+		assert.NoError(t, ioutil.WriteFile(name, []byte(content), 0755))
+	}
+
+	module := path.Join(dir, "module")
+	write(path.Join(module, "main.tf"), "module \"vpc\" {\n  source  = \"terraform-aws-modules/vpc/aws\"\n  version = \"3.18.1\"\n  cidr = \"10.0.0.0/16\"\n}\n")
+
+	// no lock file
+	key, err := cache.key(module)
+	assert.NoError(t, err)
+	assert.Empty(t, key)
+
+	write(path.Join(module, ".terraform.lock.hcl"), "provider \"registry.terraform.io/hashicorp/aws\" {\n  version = \"4.45.0\"\n}\n")
+	key, err = cache.key(module)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^sre/concourse-terraform-resource/init-cache/[0-9a-f]{64}\.tgz$`, key)
+
+	// unrelated changes keep the key, source changes invalidate it
+	write(path.Join(module, "main.tf"), "module \"vpc\" {\n  source  = \"terraform-aws-modules/vpc/aws\"\n  version = \"3.18.1\"\n  cidr = \"10.1.0.0/16\"\n}\n")
+	same, err := cache.key(module)
+	assert.NoError(t, err)
+	assert.Equal(t, key, same)
+	write(path.Join(module, "main.tf"), "module \"vpc\" {\n  source  = \"terraform-aws-modules/vpc/aws\"\n  version = \"3.19.0\"\n}\n")
+	changed, err := cache.key(module)
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, changed)
+
+	// source changes of nested local modules invalidate the key
+	write(path.Join(module, "network.tf"), "module \"network\" {\n  source = \"./modules/network\"\n}\n")
+	write(path.Join(module, "modules/network/main.tf"), "module \"subnets\" {\n  source = \"../subnets\"\n}\n")
+	write(path.Join(module, "modules/subnets/main.tf"), "module \"labels\" {\n  source  = \"cloudposse/label/null\"\n  version = \"0.25.0\"\n}\n")
+	nested, err := cache.key(module)
+	assert.NoError(t, err)
+	write(path.Join(module, "modules/subnets/main.tf"), "module \"labels\" {\n  source  = \"cloudposse/label/null\"\n  version = \"0.26.0\"\n}\n")
+	nestedChanged, err := cache.key(module)
+	assert.NoError(t, err)
+	assert.NotEqual(t, nested, nestedChanged)
+	assert.NoError(t, os.Remove(path.Join(module, "network.tf")))
+
+	// providers linked from a plugin cache are archived by content
+	pluginCache := path.Join(dir, "plugin-cache/registry.terraform.io/hashicorp/aws/4.45.0/linux_amd64")
+	write(path.Join(pluginCache, "terraform-provider-aws_v4.45.0_x5"), "provider")
+	dataDir := path.Join(dir, "run/.terraform")
+	providerDir := path.Join(dataDir, "providers/registry.terraform.io/hashicorp/aws/4.45.0")
+	assert.NoError(t, os.MkdirAll(providerDir, 0755))
+	assert.NoError(t, os.Symlink(pluginCache, path.Join(providerDir, "linux_amd64")))
+	write(path.Join(dataDir, "modules/modules.json"), `{"Modules":[]}`)
+	write(path.Join(dataDir, "terraform.tfstate"), "backend")
+
+	assert.False(t, cache.restore(key, path.Join(dir, "restored")))
+	assert.NoError(t, cache.save(key, dataDir))
+
+	restored := path.Join(dir, "restored")
+	assert.True(t, cache.restore(key, restored))
+	provider, err := ioutil.ReadFile(path.Join(restored, "providers/registry.terraform.io/hashicorp/aws/4.45.0/linux_amd64/terraform-provider-aws_v4.45.0_x5"))
+	assert.NoError(t, err)
+	assert.Equal(t, "provider", string(provider))
+	assert.FileExists(t, path.Join(restored, "modules/modules.json"))
+	assert.NoFileExists(t, path.Join(restored, "terraform.tfstate"))
+}
@@ -0,0 +1,100 @@
+package terraform
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// interruptContext returns a context that is cancelled once the process
+// receives SIGINT or SIGTERM, e.g. when a concourse build is aborted
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			logrus.Warnf("received %s, interrupting terraform", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// interruptProcessGroup forwards SIGINT to every process of the process group
+// so terraform can stop gracefully and release its state lock, processes still
+// running once the grace period expires are killed. done must yield the result
+// of waiting on the group leader.
+func interruptProcessGroup(pgid int, done <-chan error, grace time.Duration) {
+	syscall.Kill(-pgid, syscall.SIGINT)
+
+	deadline := time.After(grace)
+	select {
+	case <-done:
+	case <-deadline:
+		logrus.Warnf("ansible-playbook did not exit within %s, killing it", grace)
+		syscall.Kill(-pgid, syscall.SIGKILL)
+		<-done
+		return
+	}
+
+	// terraform may outlive ansible-playbook, wait for it to release its lock
+	tick := time.NewTicker(500 * time.Millisecond)
+	defer tick.Stop()
+	for processGroupAlive(pgid) {
+		select {
+		case <-tick.C:
+		case <-deadline:
+			logrus.Warnf("terraform did not exit within %s, killing it", grace)
+			syscall.Kill(-pgid, syscall.SIGKILL)
+			return
+		}
+	}
+}
+
+// processGroupAlive reports whether any process of the process group is still
+// running, zombies are ignored as they may never be reaped inside containers
+func processGroupAlive(pgid int) bool {
+	if syscall.Kill(-pgid, 0) != nil {
+		return false
+	}
+	procs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return true
+	}
+	for _, p := range procs {
+		if _, err := strconv.Atoi(p.Name()); err != nil {
+			continue
+		}
+		stat, err := ioutil.ReadFile(path.Join("/proc", p.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// fields following the parenthesized command: state ppid pgrp ...
+		i := strings.LastIndexByte(string(stat), ')')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(string(stat[i+1:]))
+		if len(fields) < 3 || fields[0] == "Z" {
+			continue
+		}
+		if fields[2] == strconv.Itoa(pgid) {
+			return true
+		}
+	}
+	return false
+}
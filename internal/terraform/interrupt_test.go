@@ -0,0 +1,49 @@
+package terraform
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterruptProcessGroup(t *testing.T) {
+	cases := []struct {
+		desc   string
+		script string
+		max    time.Duration
+	}{
+		{
+			desc:   "graceful",
+			script: `trap 'exit 0' INT; while true; do sleep 0.1; done`,
+			max:    3 * time.Second,
+		},
+		{
+			desc:   "killed after grace period",
+			script: `trap '' INT; while true; do sleep 0.1; done`,
+			max:    3 * time.Second,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			cmd := exec.Command("sh", "-c", c.script)
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+			assert.NoError(t, cmd.Start())
+			done := make(chan error, 1)
+			go func() {
+				done <- cmd.Wait()
+			}()
+			time.Sleep(200 * time.Millisecond)
+
+			start := time.Now()
+			interruptProcessGroup(cmd.Process.Pid, done, time.Second)
+			assert.Less(t, int64(time.Since(start)), int64(c.max))
+			assert.Eventually(t, func() bool {
+				return !processGroupAlive(cmd.Process.Pid)
+			}, time.Second, 50*time.Millisecond)
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package terraform
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// lockInfo describes a terraform state lock as reported by terraform
+type lockInfo struct {
+	ID      string
+	Who     string
+	Created string
+}
+
+var (
+	lockInfoPattern  = regexp.MustCompile(`^\s*Lock Info:\s*$`)
+	lockFieldPattern = regexp.MustCompile(`^\s*(ID|Who|Created):\s+(.+?)\s*$`)
+	lockIDPattern    = regexp.MustCompile(`(?i)\block ID(?: is)?:?\s+"?([0-9a-zA-Z_.:-]{8,})"?`)
+)
+
+// createdAt parses the lock creation time
+func (l *lockInfo) createdAt() (time.Time, error) {
+	return time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", l.Created)
+}
+
+// lockParser extracts the most recent terraform state lock from output lines
+type lockParser struct {
+	inInfo bool
+	lock   *lockInfo
+}
+
+func (l *lockParser) parseLine(line string) {
+	if lockInfoPattern.MatchString(line) {
+		l.inInfo = true
+		l.lock = &lockInfo{}
+		return
+	}
+	if l.inInfo {
+		if m := lockFieldPattern.FindStringSubmatch(line); m != nil {
+			switch m[1] {
+			case "ID":
+				l.lock.ID = m[2]
+			case "Who":
+				l.lock.Who = m[2]
+			case "Created":
+				l.lock.Created = m[2]
+			}
+			return
+		}
+		if strings.TrimSpace(line) == "" || !strings.HasPrefix(line, " ") {
+			l.inInfo = false
+		} else {
+			return
+		}
+	}
+	if m := lockIDPattern.FindStringSubmatch(line); m != nil {
+		l.lock = &lockInfo{ID: m[1]}
+	}
+}
@@ -1,7 +1,10 @@
 package terraform
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,6 +13,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/adnankobir/concourse-terraform-resource/internal/concourse"
+	"github.com/adnankobir/concourse-terraform-resource/internal/ssh"
+	"github.com/adnankobir/concourse-terraform-resource/internal/storage"
 	"github.com/adnankobir/concourse-terraform-resource/internal/types"
 	"github.com/Jeffail/benthos/v3/lib/bloblang"
 	"github.com/Jeffail/benthos/v3/lib/message"
@@ -24,6 +30,11 @@ type Out struct {
 	args   []string
 	env    types.Environment
 	input  bloblang.Message
+
+	// secrets are masked in all output
+	secrets secrets
+	// pluginCacheDir is the provider plugin cache dir of the cli config, if any
+	pluginCacheDir string
 }
 
 // NewOut instantiates a new out command executor
@@ -38,6 +49,14 @@ func NewOut(stdin io.Reader, stderr io.Writer, stdout io.Writer, args []string)
 
 // Execute handles a put operation
 func (cmd *Out) Execute() (err error) {
+	stderr := newRedactWriter(cmd.stderr, &cmd.secrets)
+	defer stderr.Flush()
+	cmd.stderr = stderr
+	defer func() {
+		if err != nil {
+			err = errors.New(cmd.secrets.redact(err.Error()))
+		}
+	}()
 	setupLogging(cmd.stderr)
 	if err := parseEnvironment(&cmd.env); err != nil {
 		return err
@@ -50,18 +69,31 @@ func (cmd *Out) Execute() (err error) {
 	if err := decoder.Decode(&req); err != nil {
 		return fmt.Errorf("invalid payload: %v", err)
 	}
+	cmd.secrets.add(req.Source.Vault.SecretID, req.Source.Concourse.Token)
+	cmd.secrets.add(req.Source.Storage.AWSSecretAccessKey, req.Source.Storage.AccountKey, req.Source.Storage.Credentials)
+	cmd.secrets.add(req.Source.Storage.Encryption.Identity)
 
 	// configure ssh
-	if keyField, ok := req.PrivateKey(); ok {
-		key, err := cmd.parseField(keyField)
+	if privateKeys := req.PrivateKeys(); len(privateKeys) > 0 || req.Source.SSH.IsSet() {
+		keys, err := cmd.parsePrivateKeys(privateKeys)
 		if err != nil {
-			return fmt.Errorf("error parsing private key: %v", err)
+			return err
 		}
-		agent, err := setupSSH(key)
-		defer agent.Shutdown()
+		agent, err := setupSSH(keys, &req.Source.SSH)
 		if err != nil {
-			return fmt.Errorf("error configuring ssh agent: %v", err)
+			return fmt.Errorf("error configuring ssh: %v", err)
 		}
+		if agent != nil {
+			defer agent.Shutdown()
+		}
+	}
+
+	// configure https git credentials and the terraform cli
+	if err := cmd.setupGitCredentials(&req.Source); err != nil {
+		return err
+	}
+	if err := cmd.setupTerraformCLI(&req.Source); err != nil {
+		return err
 	}
 
 	// change into target working directory
@@ -69,27 +101,58 @@ func (cmd *Out) Execute() (err error) {
 		return fmt.Errorf("error changing into out working directory: %v", err)
 	}
 
-	// execute ansible-playbook
-	ansible, err := cmd.ansiblePlaybookCmd(&req)
-	if err != nil {
-		return fmt.Errorf("Failed to build ansible playbook command: %v", err)
+	// interrupt terraform when the build is aborted or times out
+	ctx, stop := interruptContext()
+	defer stop()
+	if timeout := req.Params.OperationTimeout(); timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
-	if err := ansible.Run(); err != nil {
-		return fmt.Errorf("error executing ansible-playbook: %v", err)
+
+	// execute ansible-playbook
+	files := versionFiles
+	metadata := []types.Metadata{}
+	if req.Params.IsFanOut() {
+		runs, label, err := cmd.fanOutRuns(&req)
+		if err != nil {
+			return fmt.Errorf("Failed to build ansible playbook commands: %v", err)
+		}
+		err = newRunner(ctx, cmd.stderr, req.Params.Parallelism, label, req.Params.FailFast).execute(runs)
+		diags := runDiagnostics(runs)
+		writeDiagnostics(cmd.stderr, diags)
+		if err != nil {
+			return err
+		}
+		files = runVersionFiles(runs)
+		metadata = append(runMetadata(runs), diagnosticsMetadata(diags)...)
+	} else {
+		ansible, err := cmd.ansiblePlaybookCmd(&req)
+		if err != nil {
+			return fmt.Errorf("Failed to build ansible playbook command: %v", err)
+		}
+		err = ansible.Run(ctx)
+		diags := mergeDiagnostics(ansible.diagnostics)
+		writeDiagnostics(cmd.stderr, diags)
+		if err != nil {
+			return fmt.Errorf("error executing ansible-playbook: %v", err)
+		}
+		metadata = append(metadata, diagnosticsMetadata(diags)...)
 	}
 
-	versionID, err := ioutil.ReadFile("version_id")
+	// persist version files
+	version, err := cmd.putVersion(&req.Source, files)
 	if err != nil {
-		versionID = []byte(time.Now().Format(time.RFC3339Nano))
-		// return fmt.Errorf("error reading version_id from file system after playbook: %v", err)
+		return fmt.Errorf("error storing version: %v", err)
 	}
 
-	version := types.Version{
-		Key:       req.Source.Storage.Key,
-		VersionID: string(versionID),
+	// the response is not pattern redacted, which could corrupt the version
+	for i := range metadata {
+		metadata[i].Value = cmd.secrets.redactValues(metadata[i].Value)
 	}
 	resp := types.OutResponse{
-		Version: version,
+		Version:  version,
+		Metadata: metadata,
 	}
 
 	if err := json.NewEncoder(cmd.stdout).Encode(&resp); err != nil {
@@ -99,6 +162,51 @@ func (cmd *Out) Execute() (err error) {
 	return nil
 }
 
+// interpolate private keys, their passphrases and certificates
+func (cmd *Out) parsePrivateKeys(privateKeys []types.PrivateKey) ([]ssh.Key, error) {
+	keys := make([]ssh.Key, len(privateKeys))
+	for i, k := range privateKeys {
+		fields := []string{k.Key, k.Passphrase, k.Certificate}
+		for j, f := range fields {
+			parsed, err := cmd.parseField(f)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing private key (%d): %v", i, err)
+			}
+			fields[j] = parsed
+		}
+		cmd.secrets.add(fields[0], fields[1])
+		keys[i] = ssh.Key{
+			PrivateKey:  []byte(fields[0]),
+			Passphrase:  []byte(fields[1]),
+			Certificate: []byte(fields[2]),
+		}
+	}
+	return keys, nil
+}
+
+// archive version files and upload them to storage
+func (cmd *Out) putVersion(src *types.Source, files []string) (types.Version, error) {
+	cfg := &src.Storage
+	store, err := storage.New(cfg, &src.Vault)
+	if err != nil {
+		return types.Version{}, err
+	}
+
+	var archive bytes.Buffer
+	if err := createArchive(&archive, cmd.args[1], files); err != nil {
+		return types.Version{}, err
+	}
+
+	versionID, err := store.Put(cfg.Key, &archive)
+	if err != nil {
+		return types.Version{}, err
+	}
+	return types.Version{
+		Key:       cfg.Key,
+		VersionID: versionID,
+	}, nil
+}
+
 // prepare ansible-playbook command
 func (cmd *Out) ansiblePlaybookCmd(req *types.OutRequest) (*Ansible, error) {
 	// validate put request
@@ -109,22 +217,72 @@ func (cmd *Out) ansiblePlaybookCmd(req *types.OutRequest) (*Ansible, error) {
 	req.Source.AssignFallbackValues(cmd.env.Team, cmd.env.Pipeline)
 
 	// compute operation input
-	var err error
-	if req.Params.InputMapping != "" {
-		cmd.input, err = cmd.parseMapping(req.Params.InputMapping)
+	if err := cmd.parseInput(req); err != nil {
+		return nil, err
+	}
+
+	return cmd.buildAnsible(req)
+}
+
+// build ansible-playbook command from a validated request and computed input
+func (cmd *Out) buildAnsible(req *types.OutRequest) (*Ansible, error) {
+	playbook := "/opt/ansible/out.yml"
+	if req.Params.Ansible.Playbook != "" {
+		p, err := cmd.parseInputPath(req.Params.Ansible.Playbook)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing input context: %v", err)
+			return nil, fmt.Errorf("error parsing ansible playbook: %v", err)
+		}
+		playbook = p
+	}
+	ansible := NewAnsible(&req.Source, cmd.stderr, &cmd.env, playbook, cmd.args[1])
+	ansible.secrets = &cmd.secrets
+	ansible.gracePeriod = req.Params.InterruptGracePeriod()
+	ansible.heartbeat = req.Params.Heartbeat()
+	if req.Params.Retry.Attempts() > 1 {
+		retry, err := newRetryPolicy(&req.Params.Retry)
+		if err != nil {
+			return nil, err
+		}
+		ansible.retry = retry
+	}
+	// terragrunt manages its own working directories and state locks
+	terragrunt := req.Source.EngineType() == types.EngineTerragrunt
+	if req.Source.InitCache.Enabled && !terragrunt {
+		store, err := storage.New(&req.Source.Storage, &req.Source.Vault)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring init cache: %v", err)
+		}
+		// providers installed by tofu and terraform are not interchangeable
+		prefix := req.Source.InitCache.Prefix
+		if engine := req.Source.EngineType(); engine != types.EngineTerraform {
+			prefix = path.Join(prefix, engine)
+		}
+		ansible.initCache = &initCache{store: store, prefix: prefix}
+	}
+	if after := req.Params.StaleLockAge(); after > 0 && !terragrunt {
+		atcURL := req.Source.Concourse.URL
+		if atcURL == "" {
+			atcURL = cmd.env.ATCExternalURL
+		}
+		ansible.unlock = &lockGuard{
+			after:     after,
+			team:      cmd.env.Team,
+			pipeline:  cmd.env.Pipeline,
+			job:       cmd.env.Job,
+			concourse: concourse.NewClient(atcURL, req.Source.Concourse.Token),
+			now:       time.Now,
 		}
 	}
-
-	ansible := NewAnsible(&req.Source, cmd.stderr, &cmd.env, "/opt/ansible/out.yml", cmd.args[1])
 
 	// merge user provided environment variables
 	for k, v := range req.Envs() {
-		parsed, err := cmd.parseField(v)
+		parsed, err := cmd.parseField(v.Value)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing env (%s): %v", k, err)
 		}
+		if v.Sensitive {
+			cmd.secrets.add(parsed)
+		}
 		ansible.envs = append(ansible.envs, fmt.Sprintf("%s=%s", k, parsed))
 	}
 
@@ -133,9 +291,34 @@ func (cmd *Out) ansiblePlaybookCmd(req *types.OutRequest) (*Ansible, error) {
 		return nil, fmt.Errorf("error writing ansible extra vars: %v", err)
 	}
 
+	// select the engine version of the module
+	bin, err := cmd.selectBinary(req, path.Join(cmd.args[1], req.Params.Dir))
+	if err != nil {
+		return nil, err
+	}
+	ansible.extraVars.Set(bin, "terraform_binary")
+
+	if err := cmd.injectAnsibleExtensions(ansible.extraVars, &req.Params.Ansible); err != nil {
+		return nil, err
+	}
+
 	return ansible, nil
 }
 
+// compute operation input from the input mapping
+func (cmd *Out) parseInput(req *types.OutRequest) error {
+	if req.Params.InputMapping == "" {
+		cmd.input = nil
+		return nil
+	}
+	input, err := cmd.parseMapping(req.Params.InputMapping)
+	if err != nil {
+		return fmt.Errorf("error parsing input context: %v", err)
+	}
+	cmd.input = input
+	return nil
+}
+
 // inject playbook-specific extra variables
 func (cmd *Out) injectExtraVars(extraVars *gabs.Container, req *types.OutRequest) error {
 	// set context
@@ -155,6 +338,11 @@ func (cmd *Out) injectExtraVars(extraVars *gabs.Container, req *types.OutRequest
 	}
 	extraVars.Set(workspace, "terraform_workspace")
 
+	// set terraform backend configuration
+	if err := cmd.injectBackendVars(extraVars, &req.Source, req.Params.Dir, context, workspace); err != nil {
+		return fmt.Errorf("error parsing backend config: %v", err)
+	}
+
 	// parse release version
 	if req.Params.ReleaseVersion != "" {
 		releaseVersion, err := cmd.parseField(req.Params.Context)
@@ -175,7 +363,7 @@ func (cmd *Out) injectExtraVars(extraVars *gabs.Container, req *types.OutRequest
 			if err != nil {
 				return fmt.Errorf("error parsing mapped vars: %v", err)
 			}
-			extraVars.Set(tfvarsJSON, "terraform_vars")
+			extraVars.Set(tfvarsJSON.Data(), "terraform_vars")
 		}
 	}
 
@@ -194,7 +382,38 @@ func (cmd *Out) injectExtraVars(extraVars *gabs.Container, req *types.OutRequest
 		extraVars.Set(varFiles, "terraform_var_files")
 	}
 
-	extraVars.Set(path.Join(cmd.args[1], req.Params.Dir), "terraform_path")
+	// parse hook commands into scripts, commands passed as extra vars would be
+	// evaluated as jinja templates by the playbook
+	if stages := req.Params.Hooks.Stages(); len(stages) > 0 {
+		dir, err := ioutil.TempDir("", "hooks")
+		if err != nil {
+			return fmt.Errorf("error creating hooks dir: %v", err)
+		}
+		hooks := map[string][]string{}
+		for stage, commands := range stages {
+			scripts := make([]string, len(commands))
+			for i, c := range commands {
+				command, err := cmd.parseField(c)
+				if err != nil {
+					return fmt.Errorf("error parsing %s hook (%d): %v", stage, i, err)
+				}
+				scripts[i] = path.Join(dir, fmt.Sprintf("%s-%d.sh", stage, i))
+				if err := ioutil.WriteFile(scripts[i], []byte("#!/bin/sh\n"+command+"\n"), 0700); err != nil {
+					return fmt.Errorf("error writing %s hook (%d): %v", stage, i, err)
+				}
+			}
+			hooks[stage] = scripts
+		}
+		extraVars.Set(hooks, "terraform_hooks")
+	}
+
+	terraformPath := path.Join(cmd.args[1], req.Params.Dir)
+	extraVars.Set(terraformPath, "terraform_path")
+
+	extraVars.Set(req.Source.EngineType(), "terraform_engine")
+	if req.Source.EngineType() == types.EngineTerragrunt {
+		extraVars.Set(terragruntRunAll(&req.Source.Terragrunt, terraformPath), "terragrunt_run_all")
+	}
 
 	extraVars.Set(req.Params.PlanOnly, "plan_only")
 
@@ -203,6 +422,149 @@ func (cmd *Out) injectExtraVars(extraVars *gabs.Container, req *types.OutRequest
 	return nil
 }
 
+// prefixes of variables set by the resource, including those set per run
+var reservedVarPrefixes = []string{"concourse_", "resource_", "terraform_", "terragrunt_"}
+
+// variables and registered results of the out playbook, which extra vars
+// would take precedence over
+var playbookVars = map[string]bool{
+	"apply": true, "aws_creds": true, "aws_env": true, "azure_creds": true,
+	"backend_env": true, "gcp_creds": true, "generated_var_files": true, "hook": true,
+	"hook_stage": true, "http_creds": true, "output_dir": true, "pg_creds": true,
+	"plan": true, "plan_json": true, "run_dir": true, "s3_creds": true, "team": true,
+	"terraform_env": true, "terragrunt": true, "tfsec": true,
+}
+
+// inject user provided playbook tasks and extra vars, extra vars must not
+// override the variables of the resource
+func (cmd *Out) injectAnsibleExtensions(extraVars *gabs.Container, params *types.AnsibleParams) error {
+	for name, f := range map[string]string{"resource_pre_tasks": params.PreTasks, "resource_post_tasks": params.PostTasks} {
+		if f == "" {
+			continue
+		}
+		tasks, err := cmd.parseInputPath(f)
+		if err != nil {
+			return fmt.Errorf("error parsing ansible tasks file: %v", err)
+		}
+		extraVars.Set(tasks, name)
+	}
+
+	for k, v := range params.ExtraVars {
+		if extraVars.Exists(k) || playbookVars[k] || hasAnyPrefix(k, reservedVarPrefixes) {
+			return fmt.Errorf("ansible extra var (%s) conflicts with a resource variable", k)
+		}
+		parsed, err := cmd.parseField(v)
+		if err != nil {
+			return fmt.Errorf("error parsing ansible extra var (%s): %v", k, err)
+		}
+		extraVars.Set(parsed, k)
+	}
+	return nil
+}
+
+// hasAnyPrefix returns true if s starts with any of the prefixes
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// parse the path of a file provided by a put input, relative to the put
+// working directory
+func (cmd *Out) parseInputPath(text string) (string, error) {
+	f, err := cmd.parseField(text)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(f, "/") {
+		f = path.Join(cmd.args[1], f)
+	}
+	if _, err := os.Stat(f); err != nil {
+		return "", err
+	}
+	return f, nil
+}
+
+// terragruntRunAll returns true if terragrunt runs all modules under dir,
+// defaulting to true unless dir is a terragrunt module itself
+func terragruntRunAll(cfg *types.TerragruntSource, dir string) bool {
+	if cfg.RunAll != nil {
+		return *cfg.RunAll
+	}
+	_, err := os.Stat(path.Join(dir, "terragrunt.hcl"))
+	return err != nil
+}
+
+// inject source-level terraform backend configuration
+func (cmd *Out) injectBackendVars(extraVars *gabs.Container, src *types.Source, dir, context, workspace string) error {
+	backend := src.Backend
+	mode := backend.Mode
+	if mode == "" {
+		mode = types.BackendModeMerge
+	}
+	extraVars.Set(mode, "terraform_backend_mode")
+
+	extraVars.Set(backend.BackendType(), "terraform_backend_type")
+	extraVars.Set(backend.Type != "", "terraform_backend_override")
+
+	// resolve backend credentials from source or vault
+	if len(backend.Credentials) > 0 {
+		envs := map[string]string{}
+		for name, v := range backend.Credentials {
+			parsed, err := cmd.parseField(v)
+			if err != nil {
+				return fmt.Errorf("error parsing credential (%s): %v", name, err)
+			}
+			cmd.secrets.add(parsed)
+			envs[backend.CredentialEnv(name)] = parsed
+		}
+		extraVars.Set(envs, "terraform_backend_env")
+	}
+	if vaultPath := backend.CredentialsVaultPath(cmd.env.Team); vaultPath != "" {
+		extraVars.Set(vaultPath, "terraform_backend_vault_path")
+	}
+
+	config := map[string]string{}
+	for k, v := range backend.Config {
+		parsed, err := cmd.parseField(v)
+		if err != nil {
+			return fmt.Errorf("error parsing config (%s): %v", k, err)
+		}
+		config[k] = parsed
+	}
+
+	// render backend key using component, context, dir and workspace
+	if backend.KeyTemplate != "" {
+		key, err := backendKey(src, dir, context, workspace)
+		if err != nil {
+			return err
+		}
+		config[backend.KeyField()] = key
+	}
+
+	if len(config) > 0 {
+		extraVars.Set(config, "terraform_backend_config")
+	}
+	return nil
+}
+
+// backendKey renders the backend key_template of a module
+func backendKey(src *types.Source, dir, context, workspace string) (string, error) {
+	f, err := bloblang.NewField(src.Backend.KeyTemplate)
+	if err != nil {
+		return "", fmt.Errorf("error parsing key_template: %v", err)
+	}
+	meta := gabs.New()
+	meta.Set(src.Component, "component")
+	meta.Set(context, "context")
+	meta.Set(dir, "dir")
+	meta.Set(workspace, "workspace")
+	return f.String(0, message.New([][]byte{meta.Bytes()})), nil
+}
+
 // parse text as bloblang field (ie string with embedded bloblang expressions wrapped in '${!...}')
 func (cmd *Out) parseField(text string) (string, error) {
 	input := cmd.input
@@ -13,6 +13,20 @@ import (
 )
 
 func TestAnsibleOut(t *testing.T) {
+	workdir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(path.Join(workdir, "ci"), 0755))
+	for _, f := range []string{"ci/pre.yml", "ci/playbook.yml"} {
+		assert.NoError(t, ioutil.WriteFile(path.Join(workdir, f), []byte("[]"), 0644))
+	}
+	env := types.Environment{
+		ID:             "2199",
+		Job:            "testing",
+		Name:           "217",
+		Pipeline:       "example-component",
+		Team:           "sre",
+		ATCExternalURL: "http://127.0.0.1:8080",
+	}
+
 	src := types.Source{
 		Storage: types.Storage{
 			AWSAccessKeyID:     "foo",
@@ -265,6 +279,468 @@ func TestAnsibleOut(t *testing.T) {
 				assert.True(t, gjson.GetBytes(vars, "terraform_vars.test_bool").Bool())
 			},
 		},
+		{
+			desc: "storage default credentials",
+			req: &types.OutRequest{
+				Source: types.Source{
+					Storage: types.Storage{
+						Bucket:           "foo",
+						CredentialSource: types.CredentialSourceDefault,
+						Region:           "us-east-1",
+					},
+					Vault: src.Vault,
+				},
+				Params: types.OutParams{
+					Context: "foo",
+					Dir:     "source/terraform",
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.NoError(t, err)
+			},
+		},
+		{
+			desc: "storage vault credentials",
+			req: &types.OutRequest{
+				Source: types.Source{
+					Storage: types.Storage{
+						Bucket:           "foo",
+						CredentialSource: types.CredentialSourceVault,
+						Region:           "us-east-1",
+					},
+					Vault: src.Vault,
+				},
+				Params: types.OutParams{
+					Context: "foo",
+					Dir:     "source/terraform",
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, "/aws/creds/sre", req.Source.Storage.VaultPath)
+			},
+		},
+		{
+			desc: "storage missing credential source",
+			req: &types.OutRequest{
+				Source: types.Source{
+					Storage: types.Storage{
+						Bucket: "foo",
+						Region: "us-east-1",
+					},
+					Vault: src.Vault,
+				},
+				Params: types.OutParams{
+					Context: "foo",
+					Dir:     "source/terraform",
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			desc: "backend merge",
+			req: &types.OutRequest{
+				Source: types.Source{
+					Backend: types.Backend{
+						Config: map[string]string{
+							"bucket": "my-state-bucket",
+						},
+						KeyTemplate: `${!json("component")}/${!json("workspace")}/${!json("context")}.tfstate`,
+					},
+					Storage: src.Storage,
+					Vault:   src.Vault,
+				},
+				Params: types.OutParams{
+					Context:   "foo",
+					Dir:       "source/terraform",
+					Workspace: "bar",
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.NoError(t, err)
+
+				extraVars, err := ansible.prepareRun()
+				assert.NoError(t, err)
+				defer os.Remove(extraVars.Name())
+
+				vars, err := ioutil.ReadFile(extraVars.Name())
+				assert.NoError(t, err)
+				assert.Equal(t, "merge", gjson.GetBytes(vars, "terraform_backend_mode").String())
+				assert.Equal(t, "s3", gjson.GetBytes(vars, "terraform_backend_type").String())
+				assert.False(t, gjson.GetBytes(vars, "terraform_backend_override").Bool())
+				assert.Equal(t, "/aws/creds/sre", gjson.GetBytes(vars, "terraform_backend_vault_path").String())
+				assert.False(t, gjson.GetBytes(vars, "terraform_backend_env").Exists())
+				assert.Equal(t, "my-state-bucket", gjson.GetBytes(vars, "terraform_backend_config.bucket").String())
+				assert.Equal(t, "example-component/bar/foo.tfstate", gjson.GetBytes(vars, "terraform_backend_config.key").String())
+			},
+		},
+		{
+			desc: "backend override",
+			req: &types.OutRequest{
+				Source: types.Source{
+					Backend: types.Backend{
+						Type: "s3",
+						Mode: types.BackendModeOverride,
+						Config: map[string]string{
+							"region": `${!json("region")}`,
+						},
+					},
+					Storage: src.Storage,
+					Vault:   src.Vault,
+				},
+				Params: types.OutParams{
+					InputMapping: `region = "us-east-2"`,
+					Context:      "foo",
+					Dir:          "source/terraform",
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.NoError(t, err)
+
+				extraVars, err := ansible.prepareRun()
+				assert.NoError(t, err)
+				defer os.Remove(extraVars.Name())
+
+				vars, err := ioutil.ReadFile(extraVars.Name())
+				assert.NoError(t, err)
+				assert.Equal(t, "override", gjson.GetBytes(vars, "terraform_backend_mode").String())
+				assert.Equal(t, "s3", gjson.GetBytes(vars, "terraform_backend_type").String())
+				assert.True(t, gjson.GetBytes(vars, "terraform_backend_override").Bool())
+				assert.Equal(t, "us-east-2", gjson.GetBytes(vars, "terraform_backend_config.region").String())
+				assert.False(t, gjson.GetBytes(vars, "terraform_backend_config.key").Exists())
+			},
+		},
+		{
+			desc: "backend gcs",
+			req: &types.OutRequest{
+				Source: types.Source{
+					Backend: types.Backend{
+						Type: "gcs",
+						Config: map[string]string{
+							"bucket": "my-state-bucket",
+						},
+						Credentials: map[string]string{
+							"credentials": "{}",
+						},
+						KeyTemplate: `${!json("component")}/${!json("workspace")}`,
+					},
+					Storage: src.Storage,
+					Vault:   src.Vault,
+				},
+				Params: types.OutParams{
+					Context: "foo",
+					Dir:     "source/terraform",
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.NoError(t, err)
+
+				extraVars, err := ansible.prepareRun()
+				assert.NoError(t, err)
+				defer os.Remove(extraVars.Name())
+
+				vars, err := ioutil.ReadFile(extraVars.Name())
+				assert.NoError(t, err)
+				assert.Equal(t, "gcs", gjson.GetBytes(vars, "terraform_backend_type").String())
+				assert.Equal(t, "example-component/foo", gjson.GetBytes(vars, "terraform_backend_config.prefix").String())
+				assert.Equal(t, "{}", gjson.GetBytes(vars, "terraform_backend_env.GOOGLE_CREDENTIALS").String())
+				assert.False(t, gjson.GetBytes(vars, "terraform_backend_vault_path").Exists())
+			},
+		},
+		{
+			desc: "backend unsupported credential",
+			req: &types.OutRequest{
+				Source: types.Source{
+					Backend: types.Backend{
+						Type: "pg",
+						Credentials: map[string]string{
+							"password": "foo",
+						},
+					},
+					Storage: src.Storage,
+					Vault:   src.Vault,
+				},
+				Params: types.OutParams{
+					Context: "foo",
+					Dir:     "source/terraform",
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			desc: "terragrunt",
+			req: &types.OutRequest{
+				Source: types.Source{
+					Engine:     types.EngineTerragrunt,
+					Terragrunt: types.TerragruntSource{Engine: types.EngineTofu},
+					InitCache:  types.InitCache{Enabled: true},
+					Storage:    src.Storage,
+					Vault:      src.Vault,
+				},
+				Params: types.OutParams{
+					Context:          "foo",
+					Dir:              "source/terragrunt",
+					ForceUnlockAfter: "1h",
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.NoError(t, err)
+				assert.Nil(t, ansible.initCache)
+				assert.Nil(t, ansible.unlock)
+
+				extraVars, err := ansible.prepareRun()
+				assert.NoError(t, err)
+				defer os.Remove(extraVars.Name())
+
+				vars, err := ioutil.ReadFile(extraVars.Name())
+				assert.NoError(t, err)
+				assert.Equal(t, "terragrunt", gjson.GetBytes(vars, "terraform_engine").String())
+				assert.Equal(t, "tofu", gjson.GetBytes(vars, "terraform_binary").String())
+				assert.True(t, gjson.GetBytes(vars, "terragrunt_run_all").Bool())
+			},
+		},
+		{
+			desc: "terragrunt single module",
+			req: &types.OutRequest{
+				Source: types.Source{
+					Engine:     types.EngineTerragrunt,
+					Terragrunt: types.TerragruntSource{RunAll: new(bool)},
+					Storage:    src.Storage,
+					Vault:      src.Vault,
+				},
+				Params: types.OutParams{
+					Context: "foo",
+					Dir:     "source/terragrunt",
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.NoError(t, err)
+
+				extraVars, err := ansible.prepareRun()
+				assert.NoError(t, err)
+				defer os.Remove(extraVars.Name())
+
+				vars, err := ioutil.ReadFile(extraVars.Name())
+				assert.NoError(t, err)
+				assert.Equal(t, "terraform", gjson.GetBytes(vars, "terraform_binary").String())
+				assert.False(t, gjson.GetBytes(vars, "terragrunt_run_all").Bool())
+			},
+		},
+		{
+			desc: "terragrunt unsupported engine",
+			req: &types.OutRequest{
+				Source: types.Source{
+					Engine:     types.EngineTerragrunt,
+					Terragrunt: types.TerragruntSource{Engine: types.EngineTerragrunt},
+					Storage:    src.Storage,
+					Vault:      src.Vault,
+				},
+				Params: types.OutParams{
+					Context: "foo",
+					Dir:     "source/terragrunt",
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			desc: "hooks",
+			req: &types.OutRequest{
+				Source: src,
+				Params: types.OutParams{
+					InputMapping: `namespace = "web"`,
+					Context:      "foo",
+					Dir:          "source/terraform",
+					Hooks: types.Hooks{
+						PostApply: []string{`kubectl -n ${!json("namespace")} rollout status deploy/web`, `kubectl get pod web -o go-template='{{.status.phase}}'`},
+						OnFailure: []string{"./notify.sh"},
+					},
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.NoError(t, err)
+
+				extraVars, err := ansible.prepareRun()
+				assert.NoError(t, err)
+				defer os.Remove(extraVars.Name())
+
+				vars, err := ioutil.ReadFile(extraVars.Name())
+				assert.NoError(t, err)
+				// commands are passed as scripts so that they are not templated
+				scripts := map[string]string{}
+				for stage, paths := range gjson.GetBytes(vars, "terraform_hooks").Map() {
+					for _, p := range paths.Array() {
+						script, err := ioutil.ReadFile(p.String())
+						assert.NoError(t, err)
+						scripts[stage+"/"+path.Base(p.String())] = string(script)
+					}
+				}
+				os.RemoveAll(path.Dir(gjson.GetBytes(vars, "terraform_hooks.on_failure.0").String()))
+				assert.Equal(t, map[string]string{
+					"post_apply/post_apply-0.sh": "#!/bin/sh\nkubectl -n web rollout status deploy/web\n",
+					"post_apply/post_apply-1.sh": "#!/bin/sh\nkubectl get pod web -o go-template='{{.status.phase}}'\n",
+					"on_failure/on_failure-0.sh": "#!/bin/sh\n./notify.sh\n",
+				}, scripts)
+			},
+		},
+		{
+			desc: "hooks empty command",
+			req: &types.OutRequest{
+				Source: src,
+				Params: types.OutParams{
+					Context: "foo",
+					Dir:     "source/terraform",
+					Hooks:   types.Hooks{PrePlan: []string{" "}},
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			desc: "ansible extensions",
+			out:  &Out{args: []string{"/out", workdir}, env: env},
+			req: &types.OutRequest{
+				Source: src,
+				Params: types.OutParams{
+					InputMapping: `namespace = "web"`,
+					Context:      "foo",
+					Dir:          "source/terraform",
+					Ansible: types.AnsibleParams{
+						PreTasks:  "ci/pre.yml",
+						Playbook:  "ci/playbook.yml",
+						ExtraVars: map[string]string{"namespace": `${!json("namespace")}`},
+					},
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, path.Join(workdir, "ci/playbook.yml"), ansible.playbook)
+
+				extraVars, err := ansible.prepareRun()
+				assert.NoError(t, err)
+				defer os.Remove(extraVars.Name())
+
+				vars, err := ioutil.ReadFile(extraVars.Name())
+				assert.NoError(t, err)
+				assert.Equal(t, path.Join(workdir, "ci/pre.yml"), gjson.GetBytes(vars, "resource_pre_tasks").String())
+				assert.False(t, gjson.GetBytes(vars, "resource_post_tasks").Exists())
+				assert.Equal(t, "web", gjson.GetBytes(vars, "namespace").String())
+			},
+		},
+		{
+			desc: "ansible missing tasks file",
+			out:  &Out{args: []string{"/out", workdir}, env: env},
+			req: &types.OutRequest{
+				Source: src,
+				Params: types.OutParams{
+					Context: "foo",
+					Dir:     "source/terraform",
+					Ansible: types.AnsibleParams{PostTasks: "ci/post.yml"},
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			desc: "ansible extra var conflict",
+			req: &types.OutRequest{
+				Source: src,
+				Params: types.OutParams{
+					Context: "foo",
+					Dir:     "source/terraform",
+					Ansible: types.AnsibleParams{ExtraVars: map[string]string{"run_dir": "/tmp"}},
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), "ansible extra var (run_dir) conflicts with a resource variable")
+				}
+			},
+		},
+		{
+			desc: "sensitive envs",
+			req: &types.OutRequest{
+				Source: types.Source{
+					Envs:    map[string]types.Env{"GIT_SHA": {Value: "0123abcd"}},
+					Storage: src.Storage,
+					Vault:   src.Vault,
+				},
+				Params: types.OutParams{
+					Context: "foo",
+					Dir:     "source/terraform",
+					Envs:    map[string]types.Env{"API_TOKEN": {Value: "${!\"tok\" + \"en-value\"}", Sensitive: true}},
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.NoError(t, err)
+				assert.Contains(t, ansible.envs, "API_TOKEN=token-value")
+				assert.Contains(t, ansible.envs, "GIT_SHA=0123abcd")
+				assert.Equal(t, "API_TOKEN=*** GIT_SHA=0123abcd", out.secrets.redact("API_TOKEN=token-value GIT_SHA=0123abcd"))
+			},
+		},
+		{
+			desc: "sensitive backend credentials",
+			req: &types.OutRequest{
+				Source: types.Source{
+					Backend: types.Backend{
+						Type:        "azurerm",
+						Credentials: map[string]string{"client_secret": "${!\"arm\" + \"-secret\"}"},
+					},
+					Storage: src.Storage,
+					Vault:   src.Vault,
+				},
+				Params: types.OutParams{
+					Context: "foo",
+					Dir:     "source/terraform",
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, "client_secret: ***", out.secrets.redactValues("client_secret: arm-secret"))
+			},
+		},
+		{
+			desc: "heartbeat interval too short",
+			req: &types.OutRequest{
+				Source: src,
+				Params: types.OutParams{
+					Context:           "foo",
+					Dir:               "source/terraform",
+					HeartbeatInterval: "1ns",
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), "invalid parameter (heartbeat_interval), must be at least 1s")
+				}
+			},
+		},
+		{
+			desc: "backend invalid mode",
+			req: &types.OutRequest{
+				Source: types.Source{
+					Backend: types.Backend{
+						Mode: "replace",
+					},
+					Storage: src.Storage,
+					Vault:   src.Vault,
+				},
+				Params: types.OutParams{
+					Context: "foo",
+					Dir:     "source/terraform",
+				},
+			},
+			assert: func(out *Out, req *types.OutRequest, ansible *Ansible, err error) {
+				assert.Error(t, err)
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -273,14 +749,7 @@ func TestAnsibleOut(t *testing.T) {
 			if out == nil {
 				out = &Out{
 					args: []string{"/out", "/tmp/build/put"},
-					env: types.Environment{
-						ID:             "2199",
-						Job:            "testing",
-						Name:           "217",
-						Pipeline:       "example-component",
-						Team:           "sre",
-						ATCExternalURL: "http://127.0.0.1:8080",
-					},
+					env:  env,
 				}
 			}
 			ansible, err := out.ansiblePlaybookCmd(c.req)
@@ -0,0 +1,123 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+)
+
+// files of the module directory that are not linked into a region module
+var regionExcludes = map[string]bool{
+	".terraform":                true,
+	"provider_override.tf.json": true,
+	"backend_override.tf.json":  true,
+	"resource.auto.tfvars.json": true,
+}
+
+// prepare one ansible-playbook command per region, each run targets a region
+// suffixed workspace and receives the region as a terraform variable
+func (cmd *Out) regionRuns(req *types.OutRequest) ([]*run, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cmd.parseInput(req); err != nil {
+		return nil, err
+	}
+	base, err := cmd.parseField(req.Params.Workspace)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing workspace: %v", err)
+	}
+	if base == "" {
+		if base, err = cmd.parseField(req.Params.Context); err != nil {
+			return nil, fmt.Errorf("error parsing context: %v", err)
+		}
+	}
+	provider, err := cmd.parseField(req.Params.ProviderOverride)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing provider_override: %v", err)
+	}
+
+	entries := make([]types.WorkspaceEntry, 0, len(req.Params.Regions))
+	regions := make([]string, 0, len(req.Params.Regions))
+	for i, r := range req.Params.Regions {
+		region, err := cmd.parseField(r)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing region (%d): %v", i, err)
+		}
+		if region == "" || strings.ContainsAny(region, `/\`) {
+			return nil, fmt.Errorf("invalid region (%d): %s", i, region)
+		}
+		regions = append(regions, region)
+		entries = append(entries, types.WorkspaceEntry{
+			Workspace: fmt.Sprintf("%s-%s", base, region),
+			Vars:      map[string]interface{}{"region": region},
+		})
+	}
+
+	runs, err := cmd.entryRuns(req, entries)
+	if err != nil {
+		return nil, err
+	}
+	if provider == "" {
+		return runs, nil
+	}
+
+	moduleDir := path.Join(cmd.args[1], req.Params.Dir)
+	for i, rn := range runs {
+		ansible, region := rn.ansible, regions[i]
+		rn.prepare = func() error {
+			dir, err := regionModule(moduleDir, provider, region)
+			if err != nil {
+				return fmt.Errorf("error preparing region module: %v", err)
+			}
+			ansible.extraVars.Set(dir, "terraform_path")
+			return nil
+		}
+	}
+	return runs, nil
+}
+
+// create a sibling of the module directory that links all of its files and
+// adds a provider override pinning the provider region, the sibling keeps
+// relative module sources resolvable
+func regionModule(moduleDir, provider, region string) (string, error) {
+	moduleDir = path.Clean(moduleDir)
+	dir := path.Join(path.Dir(moduleDir), fmt.Sprintf(".%s.%s", path.Base(moduleDir), region))
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	files, err := ioutil.ReadDir(moduleDir)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		if regionExcludes[f.Name()] {
+			continue
+		}
+		if err := os.Symlink(path.Join(moduleDir, f.Name()), path.Join(dir, f.Name())); err != nil {
+			return "", err
+		}
+	}
+
+	override, err := json.MarshalIndent(map[string]interface{}{
+		"provider": map[string]interface{}{
+			provider: map[string]string{"region": region},
+		},
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "provider_override.tf.json"), override, 0644); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
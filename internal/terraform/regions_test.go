@@ -0,0 +1,151 @@
+package terraform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestRegionRuns(t *testing.T) {
+	src := `"source": {
+		"storage": {"aws_access_key_id": "foo", "aws_secret_access_key": "bar", "bucket": "foo", "region": "us-east-1"},
+		"vault": {"addr": "https://vault.com", "role_id": "vault-role-id", "secret_id": "vault-secret-id"}
+	}`
+
+	cases := []struct {
+		desc    string
+		payload string
+		assert  func(string, []*run, error)
+	}{
+		{
+			desc: "regions",
+			payload: `{` + src + `, "params": {
+				"dir": "source/terraform",
+				"context": "qa1",
+				"regions": ["us-east-1", "us-west-2"]
+			}}`,
+			assert: func(dir string, runs []*run, err error) {
+				assert.NoError(t, err)
+				assert.Len(t, runs, 2)
+				assert.Equal(t, "qa1-us-west-2", runs[1].name)
+				assert.Equal(t, "qa1", runs[1].context)
+				assert.Nil(t, runs[1].prepare)
+
+				vars := extraVars(t, runs[1].ansible)
+				assert.Equal(t, "qa1-us-west-2", gjson.GetBytes(vars, "terraform_workspace").String())
+				assert.Equal(t, "us-west-2", gjson.GetBytes(vars, "terraform_vars.region").String())
+				assert.Equal(t, path.Join(dir, "source/terraform"), gjson.GetBytes(vars, "terraform_path").String())
+			},
+		},
+		{
+			desc: "provider override",
+			payload: `{` + src + `, "params": {
+				"dir": "source/terraform",
+				"context": "qa1",
+				"workspace": "monitoring",
+				"regions": ["us-east-1"],
+				"provider_override": "aws"
+			}}`,
+			assert: func(dir string, runs []*run, err error) {
+				assert.NoError(t, err)
+				assert.Len(t, runs, 1)
+				assert.Equal(t, "monitoring-us-east-1", runs[0].name)
+				assert.NoError(t, runs[0].prepare())
+
+				module := path.Join(dir, "source/.terraform.us-east-1")
+				vars := extraVars(t, runs[0].ansible)
+				assert.Equal(t, module, gjson.GetBytes(vars, "terraform_path").String())
+
+				target, err := os.Readlink(path.Join(module, "main.tf"))
+				assert.NoError(t, err)
+				assert.Equal(t, path.Join(dir, "source/terraform/main.tf"), target)
+				_, err = os.Lstat(path.Join(module, ".terraform"))
+				assert.True(t, os.IsNotExist(err))
+
+				override, err := ioutil.ReadFile(path.Join(module, "provider_override.tf.json"))
+				assert.NoError(t, err)
+				assert.Equal(t, "us-east-1", gjson.GetBytes(override, "provider.aws.region").String())
+			},
+		},
+		{
+			desc: "mutually exclusive",
+			payload: `{` + src + `, "params": {
+				"dir": "source/terraform",
+				"context": "qa1",
+				"regions": ["us-east-1"],
+				"workspaces": [{"workspace": "qa1-use1"}]
+			}}`,
+			assert: func(dir string, runs []*run, err error) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			desc: "provider override without regions",
+			payload: `{` + src + `, "params": {
+				"dir": "source/terraform",
+				"context": "qa1",
+				"provider_override": "aws"
+			}}`,
+			assert: func(dir string, runs []*run, err error) {
+				assert.Error(t, err)
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			module := path.Join(dir, "source/terraform")
+			assert.NoError(t, os.MkdirAll(path.Join(module, ".terraform"), 0755))
+			assert.NoError(t, ioutil.WriteFile(path.Join(module, "main.tf"), []byte(""), 0644))
+
+			var req types.OutRequest
+			assert.NoError(t, json.Unmarshal([]byte(c.payload), &req))
+			out := &Out{
+				args: []string{"/out", dir},
+				env: types.Environment{
+					ID:             "2199",
+					Job:            "testing",
+					Name:           "217",
+					Pipeline:       "example-component",
+					Team:           "sre",
+					ATCExternalURL: "http://127.0.0.1:8080",
+				},
+			}
+			runs, err := out.regionRuns(&req)
+			c.assert(dir, runs, err)
+		})
+	}
+}
+
+func TestRunnerFailFast(t *testing.T) {
+	cases := []struct {
+		desc     string
+		failFast bool
+		statuses []string
+	}{
+		{desc: "continue on error", statuses: []string{"failed", "failed"}},
+		{desc: "fail fast", failFast: true, statuses: []string{"failed", "skipped"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			fail := func() error { return errors.New("boom") }
+			runs := []*run{
+				{name: "qa1-us-east-1", prepare: fail},
+				{name: "qa1-us-west-2", prepare: fail},
+			}
+			err := newRunner(context.Background(), ioutil.Discard, 1, "workspace", c.failFast).execute(runs)
+			assert.Error(t, err)
+			assert.Equal(t, c.statuses, []string{runs[0].status(), runs[1].status()})
+		})
+	}
+}
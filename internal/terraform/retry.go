@@ -0,0 +1,77 @@
+package terraform
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+)
+
+// ansible tasks executing terraform operations, see ansible/out.yml
+var (
+	planTasks  = map[string]bool{"run terraform init from cache": true, "run terraform plan": true, "run terragrunt plan": true}
+	applyTasks = map[string]bool{"run terraform apply": true, "run terraform destroy": true, "run terragrunt apply": true, "run terragrunt destroy": true}
+)
+
+// retryPolicy decides whether and when failed runs are retried
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+	errors   []*regexp.Regexp
+	apply    bool
+}
+
+// newRetryPolicy compiles a retry policy from a validated retry configuration
+func newRetryPolicy(cfg *types.Retry) (*retryPolicy, error) {
+	p := &retryPolicy{
+		attempts: cfg.Attempts(),
+		backoff:  cfg.BackoffDuration(),
+		apply:    cfg.Apply,
+	}
+	for _, expr := range cfg.Patterns() {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry error pattern (%s): %v", expr, err)
+		}
+		p.errors = append(p.errors, re)
+	}
+	return p, nil
+}
+
+// retryable reports whether a failed run may be retried and why
+func (p *retryPolicy) retryable(res runResult) (bool, string) {
+	switch {
+	case planTasks[res.failedTask]:
+	case applyTasks[res.failedTask]:
+		if !p.apply {
+			return false, fmt.Sprintf("task (%s) failed and retrying apply is not allowed", res.failedTask)
+		}
+	case res.failedTask == "":
+		return false, "no failed task found"
+	default:
+		return false, fmt.Sprintf("task (%s) is not retryable", res.failedTask)
+	}
+	for _, re := range p.errors {
+		if re.MatchString(res.failure) {
+			return true, fmt.Sprintf("error matches (%s)", re)
+		}
+	}
+	return false, "error does not match any retryable error"
+}
+
+// maximum delay between attempts
+const maxRetryDelay = 30 * time.Minute
+
+// delay returns the backoff before the attempt following attempt, doubling
+// with every attempt up to maxRetryDelay
+func (p *retryPolicy) delay(attempt int) time.Duration {
+	d := p.backoff
+	for i := 1; i < attempt && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return d
+}
@@ -0,0 +1,75 @@
+package terraform
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	cases := []struct {
+		desc      string
+		cfg       types.Retry
+		res       runResult
+		retryable bool
+	}{
+		{
+			desc:      "throttled plan",
+			cfg:       types.Retry{MaxAttempts: 3},
+			res:       runResult{failedTask: "run terraform plan", failure: "Error: RequestLimitExceeded: Request limit exceeded."},
+			retryable: true,
+		},
+		{
+			desc:      "module download",
+			cfg:       types.Retry{MaxAttempts: 3},
+			res:       runResult{failedTask: "run terraform plan", failure: "Error: Failed to download module"},
+			retryable: true,
+		},
+		{
+			desc: "permanent plan error",
+			cfg:  types.Retry{MaxAttempts: 3},
+			res:  runResult{failedTask: "run terraform plan", failure: "Error: Unsupported argument"},
+		},
+		{
+			desc: "apply not allowed",
+			cfg:  types.Retry{MaxAttempts: 3},
+			res:  runResult{failedTask: "run terraform apply", failure: "Throttling: Rate exceeded"},
+		},
+		{
+			desc:      "apply allowed",
+			cfg:       types.Retry{MaxAttempts: 3, Apply: true},
+			res:       runResult{failedTask: "run terraform apply", failure: "Throttling: Rate exceeded"},
+			retryable: true,
+		},
+		{
+			desc: "custom errors",
+			cfg:  types.Retry{MaxAttempts: 3, Errors: []string{"InvalidClientTokenId"}},
+			res:  runResult{failedTask: "run terraform plan", failure: "Throttling: Rate exceeded"},
+		},
+		{
+			desc: "other task",
+			cfg:  types.Retry{MaxAttempts: 3},
+			res:  runResult{failedTask: "tfsec", failure: "Throttling: Rate exceeded"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			assert.NoError(t, c.cfg.Validate())
+			p, err := newRetryPolicy(&c.cfg)
+			assert.NoError(t, err)
+			retryable, reason := p.retryable(c.res)
+			assert.Equal(t, c.retryable, retryable, reason)
+		})
+	}
+
+	p, err := newRetryPolicy(&types.Retry{MaxAttempts: 4, Backoff: "5s"})
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second},
+		[]time.Duration{p.delay(1), p.delay(2), p.delay(3)})
+	assert.Equal(t, maxRetryDelay, p.delay(100))
+	assert.Error(t, (&types.Retry{MaxAttempts: 11}).Validate())
+	assert.Error(t, (&types.Retry{Errors: []string{"("}}).Validate())
+}
@@ -0,0 +1,174 @@
+package terraform
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// mask replaces redacted secrets in output
+const mask = "***"
+
+// minimum length of redacted secrets, shorter values would mask unrelated
+// output
+const minSecretLength = 4
+
+// maximum number of lines of a private key block buffered before it is masked
+const maxPrivateKeyLines = 200
+
+var (
+	// aws access key ids of long-term and temporary credentials
+	awsAccessKeyPattern = regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)
+	// values assigned to well known secret fields, eg. "secret_key": "...",
+	// AWS_SESSION_TOKEN=... or aws_secret_access_key = ..., which are resolved
+	// from vault by the playbook and not known to the resource
+	secretFieldPattern = regexp.MustCompile(`(?i)(\b(?:aws_secret_access_key|aws_session_token|aws_security_token|secret_key|security_token|session_token|secret_id)["']?\s*[:=]\s*["']?)[^\s"',}()][^\s"',}]*`)
+	// private key blocks, up to the end of the line if the block spans lines
+	privateKeyPattern      = regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----.*?(?:-----END [A-Z0-9 ]*PRIVATE KEY-----|$)`)
+	privateKeyBeginPattern = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----`)
+	privateKeyEndPattern   = regexp.MustCompile(`-----END [A-Z0-9 ]*PRIVATE KEY-----`)
+)
+
+// secrets holds values that are masked in all output
+type secrets struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+// add registers values to be redacted, each line of multi-line values is
+// registered as well so values are masked when output line by line or with
+// escaped newlines
+func (s *secrets) add(values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range values {
+		candidates := []string{v}
+		if strings.Contains(v, "\n") {
+			candidates = append(candidates, strings.Split(v, "\n")...)
+		}
+		for _, c := range candidates {
+			c = strings.TrimSpace(c)
+			if len(c) < minSecretLength || s.contains(c) {
+				continue
+			}
+			s.values = append(s.values, c)
+		}
+	}
+	// replace longer secrets first, they may contain shorter ones
+	sort.Slice(s.values, func(i, j int) bool {
+		return len(s.values[i]) > len(s.values[j])
+	})
+}
+
+// contains returns true if v is registered, the caller must hold the lock
+func (s *secrets) contains(v string) bool {
+	for _, existing := range s.values {
+		if existing == v {
+			return true
+		}
+	}
+	return false
+}
+
+// redact masks all registered secrets, aws access keys, well known secret
+// fields and private keys in str
+func (s *secrets) redact(str string) string {
+	str = s.redactValues(str)
+	str = privateKeyPattern.ReplaceAllString(str, mask)
+	str = awsAccessKeyPattern.ReplaceAllString(str, mask)
+	return secretFieldPattern.ReplaceAllString(str, "${1}"+mask)
+}
+
+// redactValues masks the registered secrets in str only
+func (s *secrets) redactValues(str string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, v := range s.values {
+		str = strings.ReplaceAll(str, v, mask)
+	}
+	return str
+}
+
+// redactWriter masks secrets in output written to w, output is buffered
+// until a line is complete so secrets split across writes are masked. The
+// lines of private key blocks spanning several lines are masked until the end
+// of the block.
+type redactWriter struct {
+	w            io.Writer
+	secrets      *secrets
+	mu           sync.Mutex
+	buf          []byte
+	privateKey   bool
+	privateLines int
+}
+
+// newRedactWriter instantiates a new redactWriter writing to w
+func newRedactWriter(w io.Writer, s *secrets) *redactWriter {
+	return &redactWriter{w: w, secrets: s}
+}
+
+// Write implements io.Writer
+func (r *redactWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf = append(r.buf, p...)
+	i := bytes.LastIndexByte(r.buf, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	lines := string(r.buf[:i+1])
+	r.buf = append([]byte{}, r.buf[i+1:]...)
+	if _, err := io.WriteString(r.w, r.redactLines(lines)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactLines masks secrets in complete lines
+func (r *redactWriter) redactLines(lines string) string {
+	var out strings.Builder
+	for _, line := range strings.SplitAfter(lines, "\n") {
+		if line == "" {
+			continue
+		}
+		out.WriteString(r.redactLine(line))
+	}
+	return out.String()
+}
+
+// redactLine masks secrets in a line, tracking private key blocks spanning
+// several lines
+func (r *redactWriter) redactLine(line string) string {
+	eol := line[len(strings.TrimRight(line, "\r\n")):]
+	if r.privateKey {
+		r.privateLines++
+		if end := privateKeyEndPattern.FindStringIndex(line); end != nil {
+			r.privateKey = false
+			return mask + r.secrets.redact(line[end[1]:])
+		}
+		if r.privateLines >= maxPrivateKeyLines {
+			r.privateKey = false
+		}
+		return mask + eol
+	}
+	if begin := privateKeyBeginPattern.FindStringIndex(line); begin != nil && !privateKeyEndPattern.MatchString(line[begin[1]:]) {
+		r.privateKey = true
+		r.privateLines = 0
+	}
+	return r.secrets.redact(line[:len(line)-len(eol)]) + eol
+}
+
+// Flush writes buffered output of an incomplete line
+func (r *redactWriter) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(r.w, r.redactLine(string(r.buf)))
+	r.buf = nil
+	return err
+}
@@ -0,0 +1,137 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	variableBlockPattern = regexp.MustCompile(`(?m)^\s*variable\s+"([^"]+)"\s*\{`)
+	sensitivePattern     = regexp.MustCompile(`\bsensitive\s*=\s*true\b`)
+	tfvarsStringPattern  = regexp.MustCompile(`(?m)^\s*([A-Za-z_][A-Za-z0-9_-]*)\s*=\s*"((?:[^"\\]|\\.)*)"`)
+)
+
+// sensitiveVariables returns the names of the variables declared sensitive in
+// a module's configuration files
+func sensitiveVariables(dir string) (map[string]bool, error) {
+	files, err := filepath.Glob(path.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", path.Base(f), err)
+		}
+		config := string(b)
+		for _, m := range variableBlockPattern.FindAllStringSubmatchIndex(config, -1) {
+			if sensitivePattern.MatchString(blockBody(config[m[1]:])) {
+				names[config[m[2]:m[3]]] = true
+			}
+		}
+	}
+	return names, nil
+}
+
+// blockBody returns the body of a block up to its closing brace, braces
+// within quoted strings are ignored
+func blockBody(s string) string {
+	depth := 1
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return s[:i]
+			}
+		}
+	}
+	return s
+}
+
+// sensitiveValues returns the values assigned to the sensitive variables of a
+// module by the resource variables, the var files and the module's
+// automatically loaded var files
+func sensitiveValues(dir string, vars map[string]interface{}, varFiles []string) ([]string, error) {
+	names, err := sensitiveVariables(dir)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	auto, err := filepath.Glob(path.Join(dir, "*.auto.tfvars*"))
+	if err != nil {
+		return nil, err
+	}
+	files := append([]string{path.Join(dir, "terraform.tfvars"), path.Join(dir, "terraform.tfvars.json")}, auto...)
+
+	var values []string
+	for name := range names {
+		values = append(values, stringValues(vars[name])...)
+	}
+	for _, f := range append(files, varFiles...) {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		if strings.HasSuffix(f, ".json") {
+			var fileVars map[string]interface{}
+			if err := json.Unmarshal(b, &fileVars); err != nil {
+				continue
+			}
+			for name := range names {
+				values = append(values, stringValues(fileVars[name])...)
+			}
+			continue
+		}
+		for _, m := range tfvarsStringPattern.FindAllStringSubmatch(string(b), -1) {
+			if !names[m[1]] {
+				continue
+			}
+			if v, err := strconv.Unquote(`"` + m[2] + `"`); err == nil {
+				values = append(values, v)
+			} else {
+				values = append(values, m[2])
+			}
+		}
+	}
+	return values, nil
+}
+
+// stringValues returns the string values of a variable, including those
+// nested in lists and maps. Numbers and bools are not returned, masking them
+// would mask every occurrence of the value in output.
+func stringValues(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var values []string
+		for _, e := range t {
+			values = append(values, stringValues(e)...)
+		}
+		return values
+	case []string:
+		return t
+	case map[string]interface{}:
+		var values []string
+		for _, e := range t {
+			values = append(values, stringValues(e)...)
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/Jeffail/benthos/v3/lib/message"
+	"github.com/Jeffail/gabs/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// load and validate the stack manifest of a put request, returning the
+// manifest directory relative to the put working directory
+func (cmd *Out) loadStack(req *types.OutRequest) (*types.Stack, string, error) {
+	stackPath, err := cmd.parseField(req.Params.Stack)
+	if err != nil {
+		return nil, "", fmt.Errorf("error parsing stack: %v", err)
+	}
+	data, err := ioutil.ReadFile(path.Join(cmd.args[1], stackPath))
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading stack manifest: %v", err)
+	}
+
+	var stack types.Stack
+	if err := yaml.Unmarshal(data, &stack); err != nil {
+		return nil, "", fmt.Errorf("error parsing stack manifest: %v", err)
+	}
+	if err := stack.Validate(); err != nil {
+		return nil, "", fmt.Errorf("invalid stack manifest: %v", err)
+	}
+	return &stack, path.Dir(stackPath), nil
+}
+
+// prepare one ansible-playbook command per stack module, each command is
+// built once its upstream modules have been applied so that their outputs are
+// available to the module's vars mapping
+func (cmd *Out) stackRuns(req *types.OutRequest) ([]*run, error) {
+	if err := req.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid put request: %v", err)
+	}
+	req.Source.AssignFallbackValues(cmd.env.Team, cmd.env.Pipeline)
+	if err := cmd.parseInput(req); err != nil {
+		return nil, err
+	}
+
+	stack, stackDir, err := cmd.loadStack(req)
+	if err != nil {
+		return nil, err
+	}
+	context, err := cmd.parseField(req.Params.Context)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing context: %v", err)
+	}
+	workspace, err := cmd.parseField(req.Params.Workspace)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing workspace: %v", err)
+	}
+	if workspace == "" {
+		workspace = context
+	}
+	if err := stackBackendKeys(&req.Source, stack, stackDir, context, workspace); err != nil {
+		return nil, err
+	}
+
+	modules := map[string]types.StackModule{}
+	for _, m := range stack.Modules {
+		modules[m.Name] = m
+	}
+
+	runs := make([]*run, len(stack.Modules))
+	for i, m := range stack.Modules {
+		m := m
+		rn := &run{
+			name:    m.Name,
+			context: context,
+			dir:     path.Join("stack", m.Name),
+			deps:    m.DependsOn,
+		}
+		rn.prepare = func() error {
+			r := *req
+			r.Params.Dir = path.Join(stackDir, m.Dir)
+			if m.VarsMapping != "" {
+				r.Params.VarsMapping = m.VarsMapping
+			}
+			if err := cmd.parseInput(&r); err != nil {
+				return err
+			}
+			if err := cmd.injectStackOutputs(upstreamModules(m.Name, modules)); err != nil {
+				return err
+			}
+			ansible, err := cmd.buildAnsible(&r)
+			if err != nil {
+				return fmt.Errorf("error building ansible playbook command: %v", err)
+			}
+			if err := cmd.isolate(ansible, &r, rn.dir); err != nil {
+				return err
+			}
+			rn.ansible = ansible
+			return nil
+		}
+		runs[i] = rn
+	}
+	return runs, nil
+}
+
+// stackBackendKeys ensures that each stack module has its own backend key,
+// modules sharing a state would destroy each other's resources
+func stackBackendKeys(src *types.Source, stack *types.Stack, stackDir, context, workspace string) error {
+	if src.Backend.KeyTemplate == "" {
+		return fmt.Errorf("stacks require a backend key_template referencing dir")
+	}
+	keys := map[string]string{}
+	for _, m := range stack.Modules {
+		key, err := backendKey(src, path.Join(stackDir, m.Dir), context, workspace)
+		if err != nil {
+			return err
+		}
+		if other, ok := keys[key]; ok {
+			return fmt.Errorf("modules (%s) and (%s) share backend key (%s), key_template must reference dir", other, m.Name, key)
+		}
+		keys[key] = m.Name
+	}
+	return nil
+}
+
+// upstreamModules returns the transitive dependencies of a stack module
+func upstreamModules(name string, modules map[string]types.StackModule) []string {
+	seen := map[string]bool{}
+	var upstream []string
+	var visit func(name string)
+	visit = func(name string) {
+		for _, dep := range modules[name].DependsOn {
+			if !seen[dep] {
+				seen[dep] = true
+				upstream = append(upstream, dep)
+				visit(dep)
+			}
+		}
+	}
+	visit(name)
+	return upstream
+}
+
+// injectStackOutputs exposes the output values of applied stack modules to
+// mappings and interpolations as outputs.<module>.<output>
+func (cmd *Out) injectStackOutputs(upstream []string) error {
+	input := gabs.New()
+	if cmd.input != nil {
+		parsed, err := gabs.ParseJSON(cmd.input.Get(0).Get())
+		if err != nil {
+			return fmt.Errorf("error parsing input context: %v", err)
+		}
+		if _, ok := parsed.Data().(map[string]interface{}); !ok {
+			return fmt.Errorf("input context must be an object to expose stack outputs")
+		}
+		input = parsed
+	}
+
+	for _, name := range upstream {
+		outputs, err := readOutputValues(path.Join(cmd.args[1], "stack", name, "outputs.json"))
+		if err != nil {
+			return fmt.Errorf("error reading outputs of module (%s): %v", name, err)
+		}
+		input.Set(outputs, "outputs", name)
+	}
+
+	cmd.input = message.New([][]byte{input.Bytes()})
+	return nil
+}
+
+// readOutputValues reads a terraform outputs file and returns output values by
+// name, a missing file yields no outputs
+func readOutputValues(file string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return values, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs map[string]struct {
+		Value interface{} `json:"value"`
+	}
+	if err := json.Unmarshal(data, &outputs); err != nil {
+		return nil, err
+	}
+	for k, v := range outputs {
+		values[k] = v.Value
+	}
+	return values, nil
+}
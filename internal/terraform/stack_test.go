@@ -0,0 +1,161 @@
+package terraform
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestStackRuns(t *testing.T) {
+	src := `"source": {
+		"backend": {"key_template": "${!json(\"dir\")}/${!json(\"workspace\")}.tfstate"},
+		"storage": {"aws_access_key_id": "foo", "aws_secret_access_key": "bar", "bucket": "foo", "region": "us-east-1"},
+		"vault": {"addr": "https://vault.com", "role_id": "vault-role-id", "secret_id": "vault-secret-id"}
+	}`
+
+	cases := []struct {
+		desc     string
+		manifest string
+		payload  string
+		assert   func(workdir string, runs []*run, err error)
+	}{
+		{
+			desc: "dependencies",
+			manifest: `
+modules:
+  - dir: network
+  - name: cluster
+    dir: cluster
+    depends_on: [network]
+    vars_mapping: |
+      root.vpc_id = outputs.network.vpc_id
+  - name: apps
+    dir: apps
+    depends_on: [cluster]
+    vars_mapping: |
+      root.vpc_id = outputs.network.vpc_id
+      root.cluster = outputs.cluster.name
+`,
+			payload: `{` + src + `, "params": {"context": "qa1-use2", "stack": "source/stack.yml"}}`,
+			assert: func(workdir string, runs []*run, err error) {
+				assert.NoError(t, err)
+				assert.Len(t, runs, 3)
+				assert.Equal(t, "network", runs[0].name)
+				assert.Empty(t, runs[0].deps)
+				assert.Equal(t, []string{"network"}, runs[1].deps)
+
+				// simulate upstream applies
+				assert.NoError(t, runs[0].prepare())
+				writeOutputs(t, filepath.Join(workdir, "stack", "network"), `{"vpc_id":{"value":"vpc-123","type":"string","sensitive":false}}`)
+				assert.NoError(t, runs[1].prepare())
+				writeOutputs(t, filepath.Join(workdir, "stack", "cluster"), `{"name":{"value":"qa1-use2-eks","type":"string","sensitive":false}}`)
+				assert.NoError(t, runs[2].prepare())
+
+				vars := extraVars(t, runs[0].ansible)
+				assert.Equal(t, filepath.Join(workdir, "source/network"), gjson.GetBytes(vars, "terraform_path").String())
+				assert.Equal(t, "source/network/qa1-use2.tfstate", gjson.GetBytes(vars, "terraform_backend_config.key").String())
+				assert.False(t, gjson.GetBytes(vars, "terraform_vars").Exists())
+
+				vars = extraVars(t, runs[1].ansible)
+				assert.Equal(t, "vpc-123", gjson.GetBytes(vars, "terraform_vars.vpc_id").String())
+
+				vars = extraVars(t, runs[2].ansible)
+				assert.Equal(t, "vpc-123", gjson.GetBytes(vars, "terraform_vars.vpc_id").String())
+				assert.Equal(t, "qa1-use2-eks", gjson.GetBytes(vars, "terraform_vars.cluster").String())
+				assert.Equal(t, filepath.Join(workdir, "stack/apps"), gjson.GetBytes(vars, "terraform_run_dir").String())
+			},
+		},
+		{
+			desc: "cycle",
+			manifest: `
+modules:
+  - {dir: a, depends_on: [c]}
+  - {dir: b, depends_on: [a]}
+  - {dir: c, depends_on: [b]}
+`,
+			payload: `{` + src + `, "params": {"context": "qa1-use2", "stack": "source/stack.yml"}}`,
+			assert: func(workdir string, runs []*run, err error) {
+				assert.EqualError(t, err, "invalid stack manifest: dependency cycle (a -> c -> b -> a)")
+			},
+		},
+		{
+			desc: "unknown dependency",
+			manifest: `
+modules:
+  - {dir: a, depends_on: [b]}
+`,
+			payload: `{` + src + `, "params": {"context": "qa1-use2", "stack": "source/stack.yml"}}`,
+			assert: func(workdir string, runs []*run, err error) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			desc:     "shared backend key",
+			manifest: `modules: [{dir: a}, {dir: b}]`,
+			payload: `{"source": {
+				"backend": {"key_template": "${!json(\"component\")}/${!json(\"workspace\")}.tfstate"},
+				"storage": {"aws_access_key_id": "foo", "aws_secret_access_key": "bar", "bucket": "foo", "region": "us-east-1"},
+				"vault": {"addr": "https://vault.com", "role_id": "vault-role-id", "secret_id": "vault-secret-id"}
+			}, "params": {"context": "qa1-use2", "stack": "source/stack.yml"}}`,
+			assert: func(workdir string, runs []*run, err error) {
+				assert.EqualError(t, err, "modules (a) and (b) share backend key (example-component/qa1-use2.tfstate), key_template must reference dir")
+			},
+		},
+		{
+			desc:     "missing key template",
+			manifest: `modules: [{dir: a}]`,
+			payload: `{"source": {
+				"storage": {"aws_access_key_id": "foo", "aws_secret_access_key": "bar", "bucket": "foo", "region": "us-east-1"},
+				"vault": {"addr": "https://vault.com", "role_id": "vault-role-id", "secret_id": "vault-secret-id"}
+			}, "params": {"context": "qa1-use2", "stack": "source/stack.yml"}}`,
+			assert: func(workdir string, runs []*run, err error) {
+				assert.EqualError(t, err, "stacks require a backend key_template referencing dir")
+			},
+		},
+		{
+			desc:     "stack and workspaces",
+			manifest: `modules: [{dir: a}]`,
+			payload:  `{` + src + `, "params": {"context": "qa1-use2", "stack": "source/stack.yml", "workspaces": [{"workspace": "foo"}]}}`,
+			assert: func(workdir string, runs []*run, err error) {
+				assert.Error(t, err)
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			workdir, err := ioutil.TempDir("", "put")
+			assert.NoError(t, err)
+			defer os.RemoveAll(workdir)
+			assert.NoError(t, os.MkdirAll(filepath.Join(workdir, "source"), 0755))
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(workdir, "source", "stack.yml"), []byte(c.manifest), 0644))
+
+			var req types.OutRequest
+			assert.NoError(t, json.Unmarshal([]byte(c.payload), &req))
+			out := &Out{
+				args: []string{"/out", workdir},
+				env: types.Environment{
+					ID:             "2199",
+					Job:            "testing",
+					Name:           "217",
+					Pipeline:       "example-component",
+					Team:           "sre",
+					ATCExternalURL: "http://127.0.0.1:8080",
+				},
+			}
+			runs, err := out.stackRuns(&req)
+			c.assert(workdir, runs, err)
+		})
+	}
+}
+
+func writeOutputs(t *testing.T, dir, outputs string) {
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "outputs.json"), []byte(outputs), 0644))
+}
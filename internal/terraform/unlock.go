@@ -0,0 +1,96 @@
+package terraform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/concourse"
+)
+
+// containerHandlePattern matches garden container handles, which concourse
+// containers use as hostname
+var containerHandlePattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// lockGuard decides whether a state lock may be force-unlocked, which is only
+// the case for locks older than after held by concourse builds that are no
+// longer running. Holder builds are resolved from their container, or from
+// the builds of the job running at the time the lock was created once the
+// container has been garbage collected.
+type lockGuard struct {
+	after     time.Duration
+	team      string
+	pipeline  string
+	job       string
+	concourse *concourse.Client
+	now       func() time.Time
+}
+
+// stale reports whether lock may be force-unlocked and why
+func (g *lockGuard) stale(lock *lockInfo) (bool, string) {
+	if lock.ID == "" {
+		return false, "lock ID unknown"
+	}
+	created, err := lock.createdAt()
+	if err != nil {
+		return false, fmt.Sprintf("unable to parse lock creation time (%s)", lock.Created)
+	}
+	if age := g.now().Sub(created); age < g.after {
+		return false, fmt.Sprintf("lock age %s is below force_unlock_after (%s)", age.Round(time.Second), g.after)
+	}
+
+	host := lock.Who[strings.LastIndex(lock.Who, "@")+1:]
+	if !containerHandlePattern.MatchString(host) {
+		return false, fmt.Sprintf("holder (%s) is not a concourse container", lock.Who)
+	}
+	containers, err := g.concourse.Containers(g.team)
+	if err != nil {
+		return false, fmt.Sprintf("unable to determine holder build: %v", err)
+	}
+	for _, c := range containers {
+		if c.ID != host {
+			continue
+		}
+		if c.BuildID == 0 {
+			return false, fmt.Sprintf("holder container (%s) does not belong to a build", host)
+		}
+		build, err := g.concourse.Build(c.BuildID)
+		if err != nil {
+			return false, fmt.Sprintf("unable to determine holder build: %v", err)
+		}
+		return buildStale(build)
+	}
+
+	// containers of finished builds are garbage collected, resolve the holder
+	// from the job's builds running when the lock was created
+	builds, err := g.concourse.JobBuilds(g.team, g.pipeline, g.job)
+	if err != nil {
+		return false, fmt.Sprintf("unable to determine holder build: %v", err)
+	}
+	var holder *concourse.Build
+	for i, b := range builds {
+		if b.StartTime == 0 || b.StartTime > created.Unix() || (b.EndTime != 0 && b.EndTime < created.Unix()) {
+			continue
+		}
+		if holder != nil {
+			return false, fmt.Sprintf("holder build is ambiguous, builds (%d) and (%d) were running when the lock was created", holder.ID, b.ID)
+		}
+		holder = &builds[i]
+	}
+	if holder == nil {
+		return false, "holder build could not be determined"
+	}
+	return buildStale(holder)
+}
+
+// buildStale reports whether the lock of a holder build may be force-unlocked
+func buildStale(build *concourse.Build) (bool, string) {
+	if build.Running() {
+		return false, fmt.Sprintf("holder build (%d) is still %s", build.ID, build.Status)
+	}
+	if !build.Finished() {
+		return false, fmt.Sprintf("holder build (%d) status (%s) is unknown", build.ID, build.Status)
+	}
+	return true, fmt.Sprintf("holder build (%d) %s", build.ID, build.Status)
+}
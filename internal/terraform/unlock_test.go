@@ -0,0 +1,134 @@
+package terraform
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/concourse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockGuard(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/teams/sre/containers":
+			w.Write([]byte(`[
+				{"id": "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0", "build_id": 41, "type": "put"},
+				{"id": "1a2b3c4d-5e6f-7081-9203-a4b5c6d7e8f9", "build_id": 42, "type": "put"},
+				{"id": "4d5e6f70-8192-a3b4-c5d6-e7f8091a2b3c", "build_id": 43, "type": "put"},
+				{"id": "2b3c4d5e-6f70-8192-a3b4-c5d6e7f8091a", "type": "check"}
+			]`))
+		case "/api/v1/teams/sre/pipelines/infra/jobs/apply/builds":
+			w.Write([]byte(`[
+				{"id": 40, "name": "11", "status": "succeeded", "start_time": 1665571200, "end_time": 1665572400},
+				{"id": 39, "name": "10", "status": "failed", "start_time": 1665570600, "end_time": 1665571800},
+				{"id": 38, "name": "9", "status": "errored", "start_time": 1665567600, "end_time": 1665567900}
+			]`))
+		case "/api/v1/builds/41":
+			w.Write([]byte(`{"id": 41, "name": "12", "status": "aborted"}`))
+		case "/api/v1/builds/42":
+			w.Write([]byte(`{"id": 42, "name": "13", "status": "started"}`))
+		case "/api/v1/builds/43":
+			w.Write([]byte(`{"id": 43, "name": "14", "status": "unknown"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	now, _ := time.Parse(time.RFC3339, "2022-10-12T12:00:00Z")
+	created := "2022-10-12 09:41:07.123456 +0000 UTC"
+
+	cases := []struct {
+		desc   string
+		token  string
+		lock   *lockInfo
+		stale  bool
+		reason string
+	}{
+		{
+			desc:   "finished build",
+			lock:   &lockInfo{ID: "a", Who: "root@0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0", Created: created},
+			stale:  true,
+			reason: "holder build (41) aborted",
+		},
+		{
+			desc:   "running build",
+			lock:   &lockInfo{ID: "a", Who: "root@1a2b3c4d-5e6f-7081-9203-a4b5c6d7e8f9", Created: created},
+			reason: "holder build (42) is still started",
+		},
+		{
+			desc:   "container gone",
+			lock:   &lockInfo{ID: "a", Who: "root@3c4d5e6f-7081-92a3-b4c5-d6e7f8091a2b", Created: created},
+			stale:  true,
+			reason: "holder build (38) errored",
+		},
+		{
+			desc:   "container gone without job build",
+			lock:   &lockInfo{ID: "a", Who: "root@3c4d5e6f-7081-92a3-b4c5-d6e7f8091a2b", Created: "2022-10-12 08:00:00 +0000 UTC"},
+			reason: "holder build could not be determined",
+		},
+		{
+			desc:   "container gone with concurrent job builds",
+			lock:   &lockInfo{ID: "a", Who: "root@3c4d5e6f-7081-92a3-b4c5-d6e7f8091a2b", Created: "2022-10-12 10:45:00 +0000 UTC"},
+			reason: "holder build is ambiguous, builds (40) and (39) were running when the lock was created",
+		},
+		{
+			desc:   "unknown build status",
+			lock:   &lockInfo{ID: "a", Who: "root@4d5e6f70-8192-a3b4-c5d6-e7f8091a2b3c", Created: created},
+			reason: "holder build (43) status (unknown) is unknown",
+		},
+		{
+			desc:   "not a build",
+			lock:   &lockInfo{ID: "a", Who: "root@2b3c4d5e-6f70-8192-a3b4-c5d6e7f8091a", Created: created},
+			reason: "holder container (2b3c4d5e-6f70-8192-a3b4-c5d6e7f8091a) does not belong to a build",
+		},
+		{
+			desc:   "not concourse",
+			lock:   &lockInfo{ID: "a", Who: "alice@laptop", Created: created},
+			reason: "holder (alice@laptop) is not a concourse container",
+		},
+		{
+			desc:   "too recent",
+			lock:   &lockInfo{ID: "a", Who: "root@0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0", Created: "2022-10-12 11:30:00 +0000 UTC"},
+			reason: "lock age 30m0s is below force_unlock_after (1h0m0s)",
+		},
+		{
+			desc:   "unauthorized",
+			token:  "wrong",
+			lock:   &lockInfo{ID: "a", Who: "root@0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0", Created: created},
+			reason: "unable to determine holder build: error listing containers: 401 Unauthorized",
+		},
+		{
+			desc:   "unknown id",
+			lock:   &lockInfo{Who: "root@0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0", Created: created},
+			reason: "lock ID unknown",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			token := c.token
+			if token == "" {
+				token = "token"
+			}
+			g := &lockGuard{
+				after:     time.Hour,
+				team:      "sre",
+				pipeline:  "infra",
+				job:       "apply",
+				concourse: concourse.NewClient(srv.URL, token),
+				now:       func() time.Time { return now },
+			}
+			stale, reason := g.stale(c.lock)
+			assert.Equal(t, c.stale, stale)
+			assert.Equal(t, c.reason, reason)
+		})
+	}
+}
@@ -3,9 +3,7 @@ package terraform
 import (
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"os/exec"
 
 	"github.com/adnankobir/concourse-terraform-resource/internal/ssh"
 	"github.com/adnankobir/concourse-terraform-resource/internal/types"
@@ -34,26 +32,53 @@ func setupLogging(stderr io.Writer) {
 	})
 }
 
-func setupSSH(key string) (*ssh.Agent, error) {
-	agent, err := ssh.SpawnAgent()
-	if err != nil {
-		return agent, fmt.Errorf("failed to spawn ssh agent: %v", err)
+// setupSSH spawns an ssh agent holding keys, if provided, and configures git to
+// verify host keys against a generated known_hosts file
+func setupSSH(keys []ssh.Key, cfg *types.SSHSource) (*ssh.Agent, error) {
+	var agent *ssh.Agent
+	if len(keys) > 0 {
+		var err error
+		if agent, err = ssh.SpawnAgent(); err != nil {
+			return nil, fmt.Errorf("failed to spawn ssh agent: %v", err)
+		}
+		for i, key := range keys {
+			if err := agent.AddKey(key); err != nil {
+				agent.Shutdown()
+				return nil, fmt.Errorf("failed to add private key (%d): %v", i, err)
+			}
+		}
+		if err := os.Setenv("SSH_AUTH_SOCK", agent.SSHAuthSock()); err != nil {
+			agent.Shutdown()
+			return nil, fmt.Errorf("failed to set agent forwarding: %v", err)
+		}
+	}
+
+	sshCmd, err := gitSSHCommand(cfg)
+	if err == nil {
+		err = os.Setenv("GIT_SSH_COMMAND", sshCmd)
 	}
-	err = agent.AddKey([]byte(key))
 	if err != nil {
-		log.Fatalf("Failed to add private key: %v", err)
+		if agent != nil {
+			agent.Shutdown()
+		}
+		return nil, fmt.Errorf("failed to configure host key checking: %v", err)
+	}
+	return agent, nil
+}
+
+// gitSSHCommand returns the ssh command used by git, writing a known_hosts
+// file if host keys are verified
+func gitSSHCommand(cfg *types.SSHSource) (string, error) {
+	if !cfg.Strict() {
+		return "ssh -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null", nil
 	}
-	err = os.Setenv("SSH_AUTH_SOCK", agent.SSHAuthSock())
+	knownHosts, err := ssh.KnownHosts(cfg.KnownHosts, cfg.ScanHosts())
 	if err != nil {
-		log.Fatalf("Failed to set agent forwarding: %v", err)
+		return "", err
 	}
-	cmd := exec.Command("ssh-keyscan", "-H", "github.com", ">>", "~/.ssh/known_hosts")
-	//cmd.Stdout = os.Stderr
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-	err = cmd.Run()
+	path, err := ssh.WriteKnownHosts(knownHosts)
 	if err != nil {
-		log.Fatalf("Failed to add github.com to known hosts: %v", err)
+		return "", err
 	}
-	return agent, err
+	return fmt.Sprintf("ssh -o StrictHostKeyChecking=yes -o UserKnownHostsFile=%s", path), nil
 }
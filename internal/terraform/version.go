@@ -0,0 +1,355 @@
+package terraform
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/hashicorp/go-version"
+	"github.com/sirupsen/logrus"
+)
+
+// pinnedVersionFiles pin the engine version of a module, as used by tfenv
+// and tofuenv
+var pinnedVersionFiles = map[string]string{
+	types.EngineTerraform: ".terraform-version",
+	types.EngineTofu:      ".opentofu-version",
+}
+
+// requiredVersion matches required_version constraints in terraform blocks
+var requiredVersion = regexp.MustCompile(`\brequired_version\s*=\s*"([^"]+)"`)
+
+// installer selects binaries installed under a versions dir, as
+// <dir>/<version>/<product> alongside a <product>.sha256 checksum file, and
+// installs missing versions from a local mirror of the release archives
+type installer struct {
+	product string
+	dir     string
+	mirror  string
+	client  *http.Client
+}
+
+// newInstaller instantiates an installer of the source's engine
+func newInstaller(src *types.Source) *installer {
+	engine := src.TerraformEngine()
+	return &installer{
+		product: engine,
+		dir:     src.TerraformVersions.VersionsDir(engine),
+		mirror:  strings.TrimSuffix(src.TerraformVersions.Mirror, "/"),
+		client:  http.DefaultClient,
+	}
+}
+
+// select the engine binary of a module, the version is taken from the
+// terraform_version param, the module's pinned version file, or the newest
+// installed version satisfying its required_version constraints, in that
+// order. The default binary of the engine is used if no version is selected
+// or it satisfies the required_version constraints, so that installing newer
+// versions does not upgrade modules with open constraints
+func (cmd *Out) selectBinary(req *types.OutRequest, moduleDir string) (string, error) {
+	i := newInstaller(&req.Source)
+	pinned, err := cmd.parseField(req.Params.TerraformVersion)
+	if err != nil {
+		return "", fmt.Errorf("error parsing terraform_version: %v", err)
+	}
+	if pinned == "" {
+		if pinned, err = readVersionFile(path.Join(moduleDir, pinnedVersionFiles[i.product])); err != nil {
+			return "", err
+		}
+	}
+	constraints, err := moduleConstraints(moduleDir)
+	if err != nil {
+		return "", err
+	}
+
+	var v *version.Version
+	if pinned != "" {
+		if v, err = version.NewVersion(pinned); err != nil {
+			return "", fmt.Errorf("invalid %s version (%s): %v", i.product, pinned, err)
+		}
+		if !constraints.Check(v) {
+			return "", fmt.Errorf("%s %s does not satisfy required_version (%s)", i.product, v, constraints)
+		}
+	} else {
+		if len(constraints) == 0 {
+			return i.product, nil
+		}
+		if def := i.defaultVersion(); def != nil && constraints.Check(def) {
+			return i.product, nil
+		}
+		installed, err := i.installed()
+		if err != nil {
+			return "", err
+		}
+		if len(installed) == 0 {
+			return i.product, nil
+		}
+		for j := len(installed) - 1; j >= 0; j-- {
+			if constraints.Check(installed[j]) {
+				v = installed[j]
+				break
+			}
+		}
+		if v == nil {
+			return "", fmt.Errorf("no installed %s version satisfies required_version (%s), installed: %s", i.product, constraints, joinVersions(installed))
+		}
+	}
+
+	bin, err := i.binary(v)
+	if err != nil {
+		return "", err
+	}
+	logrus.Infof("using %s %s (%s)", i.product, v, bin)
+	return bin, nil
+}
+
+// read the version pinned by a version file, if any
+func readVersionFile(f string) (string, error) {
+	b, err := ioutil.ReadFile(f)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %v", path.Base(f), err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// parse the required_version constraints of a module's configuration files
+func moduleConstraints(dir string) (version.Constraints, error) {
+	files, err := filepath.Glob(path.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	var constraints version.Constraints
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", path.Base(f), err)
+		}
+		for _, m := range requiredVersion.FindAllSubmatch(b, -1) {
+			c, err := version.NewConstraint(string(m[1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid required_version (%s) in %s: %v", m[1], path.Base(f), err)
+			}
+			constraints = append(constraints, c...)
+		}
+	}
+	return constraints, nil
+}
+
+// joinVersions formats a list of versions
+func joinVersions(versions version.Collection) string {
+	s := make([]string, len(versions))
+	for i, v := range versions {
+		s[i] = v.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// defaultVersion returns the version of the default binary of the engine, if
+// it is installed under the versions dir, as the image symlinks it
+func (i *installer) defaultVersion() *version.Version {
+	bin, err := exec.LookPath(i.product)
+	if err != nil {
+		return nil
+	}
+	resolved, err := filepath.EvalSymlinks(bin)
+	if err != nil {
+		return nil
+	}
+	dir, err := filepath.EvalSymlinks(i.dir)
+	if err != nil {
+		return nil
+	}
+	rel, err := filepath.Rel(dir, resolved)
+	if err != nil || filepath.Base(rel) != i.product {
+		return nil
+	}
+	v, err := version.NewVersion(filepath.Dir(rel))
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// installed returns the versions installed under the versions dir, sorted in
+// ascending order
+func (i *installer) installed() (version.Collection, error) {
+	entries, err := ioutil.ReadDir(i.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s versions: %v", i.product, err)
+	}
+	var versions version.Collection
+	for _, e := range entries {
+		v, err := version.NewVersion(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(path.Join(i.dir, e.Name(), i.product)); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	sort.Sort(versions)
+	return versions, nil
+}
+
+// binary returns the verified path of an installed version, installing it
+// from the mirror if missing
+func (i *installer) binary(v *version.Version) (string, error) {
+	dir := path.Join(i.dir, v.String())
+	bin := path.Join(dir, i.product)
+	if _, err := os.Stat(bin); os.IsNotExist(err) {
+		if i.mirror == "" {
+			return "", fmt.Errorf("%s %s is not installed in %s and no mirror is configured", i.product, v, i.dir)
+		}
+		if err := i.install(v, dir); err != nil {
+			return "", fmt.Errorf("error installing %s %s from mirror: %v", i.product, v, err)
+		}
+	}
+
+	// verify the binary against the checksum recorded at install time
+	sum, err := ioutil.ReadFile(bin + ".sha256")
+	if err != nil {
+		return "", fmt.Errorf("error reading %s %s checksum: %v", i.product, v, err)
+	}
+	fields := strings.Fields(string(sum))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty %s %s checksum", i.product, v)
+	}
+	f, err := os.Open(bin)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if actual, err := sha256Sum(f); err != nil {
+		return "", err
+	} else if actual != fields[0] {
+		return "", fmt.Errorf("%s %s checksum mismatch, expected %s but got %s", i.product, v, fields[0], actual)
+	}
+	return bin, nil
+}
+
+// install a version from the mirror, which follows the layout of the
+// terraform and tofu releases: <mirror>/<version>/<product>_<version>_SHA256SUMS
+// and <mirror>/<version>/<product>_<version>_<os>_<arch>.zip
+func (i *installer) install(v *version.Version, dir string) error {
+	name := fmt.Sprintf("%s_%s", i.product, v)
+	archive := fmt.Sprintf("%s_%s_%s.zip", name, runtime.GOOS, runtime.GOARCH)
+	sums, err := i.fetch(fmt.Sprintf("%s/%s/%s_SHA256SUMS", i.mirror, v, name))
+	if err != nil {
+		return err
+	}
+	expected, err := archiveChecksum(sums, archive)
+	if err != nil {
+		return err
+	}
+	zipped, err := i.fetch(fmt.Sprintf("%s/%s/%s", i.mirror, v, archive))
+	if err != nil {
+		return err
+	}
+	if actual, _ := sha256Sum(bytes.NewReader(zipped)); actual != expected {
+		return fmt.Errorf("%s checksum mismatch, expected %s but got %s", archive, expected, actual)
+	}
+
+	bin, err := unzipFile(zipped, i.product)
+	if err != nil {
+		return fmt.Errorf("error extracting %s: %v", archive, err)
+	}
+
+	// install into a temporary dir and rename it into place
+	if err := os.MkdirAll(i.dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(i.dir, "."+v.String())
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := ioutil.WriteFile(path.Join(tmp, i.product), bin, 0755); err != nil {
+		return err
+	}
+	sum, _ := sha256Sum(bytes.NewReader(bin))
+	if err := ioutil.WriteFile(path.Join(tmp, i.product+".sha256"), []byte(fmt.Sprintf("%s  %s\n", sum, i.product)), 0644); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+// fetch a file from the mirror, which is either a local path or an https url
+func (i *installer) fetch(location string) ([]byte, error) {
+	if strings.HasPrefix(location, "/") {
+		return ioutil.ReadFile(location)
+	}
+	resp, err := i.client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching %s: %s", location, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// archiveChecksum returns the checksum of an archive listed in a SHA256SUMS file
+func archiveChecksum(sums []byte, archive string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == archive {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("no checksum found for %s", archive)
+}
+
+// unzipFile returns the contents of a named file within a zip archive
+func unzipFile(zipped []byte, name string) ([]byte, error) {
+	r, err := zip.NewReader(bytes.NewReader(zipped), int64(len(zipped)))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range r.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+	return nil, fmt.Errorf("%s not found in archive", name)
+}
+
+// sha256Sum returns the hex encoded sha256 checksum of a reader
+func sha256Sum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
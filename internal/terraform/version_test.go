@@ -0,0 +1,242 @@
+package terraform
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectTerraform(t *testing.T) {
+	cases := []struct {
+		desc        string
+		engine      string
+		defaultBin  string
+		version     string
+		versionFile string
+		pinFile     string
+		config      string
+		mirror      bool
+		expected    string
+		err         string
+	}{
+		{
+			desc:     "default binary",
+			expected: "terraform",
+		},
+		{
+			desc:     "terraform_version param",
+			version:  "1.5.7",
+			expected: "1.5.7",
+		},
+		{
+			desc:        "terraform_version param takes precedence",
+			version:     "1.5.7",
+			versionFile: "1.3.9\n",
+			expected:    "1.5.7",
+		},
+		{
+			desc:        ".terraform-version file",
+			versionFile: "1.3.9\n",
+			expected:    "1.3.9",
+		},
+		{
+			desc:     "newest version satisfying required_version",
+			config:   `terraform { required_version = ">= 1.3.0, < 2.0.0" }`,
+			expected: "1.5.7",
+		},
+		{
+			desc:       "default version satisfying required_version",
+			defaultBin: "1.3.9",
+			config:     `terraform { required_version = ">= 1.0.0" }`,
+			expected:   "terraform",
+		},
+		{
+			desc:       "default version not satisfying required_version",
+			defaultBin: "1.3.9",
+			config:     `terraform { required_version = ">= 1.4.0" }`,
+			expected:   "1.5.7",
+		},
+		{
+			desc:     "pessimistic required_version",
+			config:   "terraform {\n  required_version = \"~> 1.3.0\"\n}\n",
+			expected: "1.3.9",
+		},
+		{
+			desc:   "no version satisfies required_version",
+			config: `terraform { required_version = ">= 2.0.0" }`,
+			err:    "no installed terraform version satisfies required_version (>= 2.0.0), installed: 1.3.9, 1.4.0, 1.5.7",
+		},
+		{
+			desc:    "pinned version does not satisfy required_version",
+			version: "1.3.9",
+			config:  `terraform { required_version = ">= 1.4.0" }`,
+			err:     "terraform 1.3.9 does not satisfy required_version (>= 1.4.0)",
+		},
+		{
+			desc:    "invalid version",
+			version: "latest",
+			err:     "invalid terraform version (latest)",
+		},
+		{
+			desc:    "missing version",
+			version: "1.6.0",
+			err:     "terraform 1.6.0 is not installed in {{dir}} and no mirror is configured",
+		},
+		{
+			desc:     "version installed from mirror",
+			version:  "1.6.0",
+			mirror:   true,
+			expected: "1.6.0",
+		},
+		{
+			desc:    "mirror checksum mismatch",
+			version: "1.6.1",
+			mirror:  true,
+			err:     "error installing terraform 1.6.1 from mirror: terraform_1.6.1_" + runtime.GOOS + "_" + runtime.GOARCH + ".zip checksum mismatch",
+		},
+		{
+			desc:    "tampered binary",
+			version: "1.4.0",
+			err:     "terraform 1.4.0 checksum mismatch",
+		},
+		{
+			desc:     "tofu default binary",
+			engine:   "tofu",
+			expected: "tofu",
+		},
+		{
+			desc:        "tofu ignores .terraform-version file",
+			engine:      "tofu",
+			versionFile: "1.5.7\n",
+			expected:    "tofu",
+		},
+		{
+			desc:        ".opentofu-version file",
+			engine:      "tofu",
+			versionFile: "1.5.7\n",
+			pinFile:     ".opentofu-version",
+			expected:    "1.5.7",
+		},
+		{
+			desc:     "tofu installed from mirror",
+			engine:   "tofu",
+			version:  "1.6.0",
+			mirror:   true,
+			expected: "1.6.0",
+		},
+		{
+			desc:    "missing tofu version",
+			engine:  "tofu",
+			version: "1.6.0",
+			err:     "tofu 1.6.0 is not installed in {{dir}} and no mirror is configured",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			engine := c.engine
+			if engine == "" {
+				engine = types.EngineTerraform
+			}
+			dir := t.TempDir()
+			versionsDir := path.Join(dir, "versions")
+			installVersion(t, versionsDir, engine, "1.3.9")
+			installVersion(t, versionsDir, engine, "1.5.7")
+			installVersion(t, versionsDir, engine, "1.4.0")
+			assert.NoError(t, ioutil.WriteFile(path.Join(versionsDir, "1.4.0", engine), []byte("tampered"), 0755))
+			mirrorDir := path.Join(dir, "mirror")
+			mirrorVersion(t, mirrorDir, engine, "1.6.0", false)
+			mirrorVersion(t, mirrorDir, engine, "1.6.1", true)
+
+			// the default binary is symlinked into PATH by the image
+			binDir := path.Join(dir, "bin")
+			assert.NoError(t, os.MkdirAll(binDir, 0755))
+			if c.defaultBin != "" {
+				assert.NoError(t, os.Symlink(path.Join(versionsDir, c.defaultBin, engine), path.Join(binDir, engine)))
+			}
+			t.Setenv("PATH", binDir)
+
+			moduleDir := path.Join(dir, "module")
+			assert.NoError(t, os.MkdirAll(moduleDir, 0755))
+			if c.versionFile != "" {
+				f := c.pinFile
+				if f == "" {
+					f = ".terraform-version"
+				}
+				assert.NoError(t, ioutil.WriteFile(path.Join(moduleDir, f), []byte(c.versionFile), 0644))
+			}
+			if c.config != "" {
+				assert.NoError(t, ioutil.WriteFile(path.Join(moduleDir, "versions.tf"), []byte(c.config), 0644))
+			}
+
+			req := types.OutRequest{
+				Source: types.Source{Engine: c.engine, TerraformVersions: types.TerraformVersions{Dir: versionsDir}},
+				Params: types.OutParams{TerraformVersion: c.version},
+			}
+			if c.mirror {
+				req.Source.TerraformVersions.Mirror = mirrorDir
+			}
+			assert.NoError(t, req.Source.TerraformVersions.Validate())
+
+			out := &Out{args: []string{"/out", dir}}
+			bin, err := out.selectBinary(&req, moduleDir)
+			if c.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), strings.ReplaceAll(c.err, "{{dir}}", versionsDir))
+				}
+				return
+			}
+			assert.NoError(t, err)
+			if c.expected == engine {
+				assert.Equal(t, engine, bin)
+				return
+			}
+			assert.Equal(t, path.Join(versionsDir, c.expected, engine), bin)
+			b, err := ioutil.ReadFile(bin)
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, string(b))
+		})
+	}
+}
+
+// installVersion writes a fake binary, printing its version, and its checksum
+// to a versions dir
+func installVersion(t *testing.T, versionsDir, product, v string) {
+	dir := path.Join(versionsDir, v)
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, product), []byte(v), 0755))
+	sum, _ := sha256Sum(bytes.NewReader([]byte(v)))
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, product+".sha256"), []byte(sum+"  "+product+"\n"), 0644))
+}
+
+// mirrorVersion writes a release archive of a fake binary and its checksums to
+// a mirror dir
+func mirrorVersion(t *testing.T, mirrorDir, product, v string, corrupt bool) {
+	var zipped bytes.Buffer
+	w := zip.NewWriter(&zipped)
+	f, err := w.Create(product)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte(v))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	dir := path.Join(mirrorDir, v)
+	archive := fmt.Sprintf("%s_%s_%s_%s.zip", product, v, runtime.GOOS, runtime.GOARCH)
+	sum, _ := sha256Sum(bytes.NewReader(zipped.Bytes()))
+	if corrupt {
+		sum = fmt.Sprintf("%064d", 0)
+	}
+	sums := fmt.Sprintf("%s  %s_%s_darwin_arm64.zip\n%s  %s\n", sum, product, v, sum, archive)
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, archive), zipped.Bytes(), 0644))
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, fmt.Sprintf("%s_%s_SHA256SUMS", product, v)), []byte(sums), 0644))
+}
@@ -0,0 +1,101 @@
+package terraform
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// maximum number of lines recorded for a failed task
+const maxFailureLines = 2000
+
+var (
+	ansiPattern       = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+	taskPattern       = regexp.MustCompile(`^TASK \[(.+?)\]`)
+	taskFailedPattern = regexp.MustCompile(`^(fatal|failed): \[`)
+)
+
+// runResult describes the outcome of an ansible-playbook run as observed in
+// its output
+type runResult struct {
+	// lock is the most recently reported terraform state lock
+	lock *lockInfo
+	// failedTask is the name of the ansible task that failed
+	failedTask string
+	// failure is the output of the failed task
+	failure string
+	// diagnostics are the terraform errors and warnings reported
+	diagnostics []diagnostic
+}
+
+// outputWatcher passes output through to w while recording the state locks,
+// terraform diagnostics and task failures reported in it
+type outputWatcher struct {
+	w        io.Writer
+	mu       sync.Mutex
+	line     []byte
+	locks    lockParser
+	diags    diagnosticParser
+	task     string
+	failing  bool
+	result   runResult
+	failures []string
+}
+
+// newOutputWatcher instantiates a new outputWatcher writing to w
+func newOutputWatcher(w io.Writer) *outputWatcher {
+	return &outputWatcher{w: w}
+}
+
+// Write implements io.Writer, lines are parsed once complete so writes split
+// across line boundaries are handled
+func (o *outputWatcher) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	o.line = append(o.line, p...)
+	for {
+		i := bytes.IndexByte(o.line, '\n')
+		if i < 0 {
+			break
+		}
+		o.parseLine(string(o.line[:i]))
+		o.line = o.line[i+1:]
+	}
+	o.mu.Unlock()
+	return o.w.Write(p)
+}
+
+// Result returns the outcome observed so far
+func (o *outputWatcher) Result() runResult {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.line) > 0 {
+		o.parseLine(string(o.line))
+		o.line = nil
+	}
+	res := o.result
+	res.lock = o.locks.lock
+	res.diagnostics = o.diags.diagnostics()
+	res.failure = strings.Join(o.failures, "\n")
+	return res
+}
+
+func (o *outputWatcher) parseLine(line string) {
+	line = strings.TrimRight(ansiPattern.ReplaceAllString(line, ""), "\r")
+	o.locks.parseLine(line)
+	o.diags.parseLine(line)
+
+	if m := taskPattern.FindStringSubmatch(line); m != nil {
+		o.task = m[1]
+		o.failing = false
+		return
+	}
+	if taskFailedPattern.MatchString(line) && o.result.failedTask == "" {
+		o.result.failedTask = o.task
+		o.failing = true
+	}
+	if o.failing && len(o.failures) < maxFailureLines {
+		o.failures = append(o.failures, line)
+	}
+}
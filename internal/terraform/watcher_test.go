@@ -0,0 +1,79 @@
+package terraform
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputWatcher(t *testing.T) {
+	cases := []struct {
+		desc   string
+		output string
+		lock   *lockInfo
+		task   string
+		failed string
+	}{
+		{
+			desc: "lock info",
+			output: `Error: Error acquiring the state lock
+
+Error message: ConditionalCheckFailedException: The conditional request failed
+Lock Info:
+  ID:        8a3f2c1e-4b5d-6e7f-8091-a2b3c4d5e6f7
+  Path:      tfstate/sre/example/qa1
+  Operation: OperationTypeApply
+  Who:       root@9c1f3e2d
+  Version:   1.3.2
+  Created:   2022-10-12 09:41:07.123456 +0000 UTC
+  Info:
+
+Terraform acquires a state lock to protect the state from being written
+`,
+			lock: &lockInfo{
+				ID:      "8a3f2c1e-4b5d-6e7f-8091-a2b3c4d5e6f7",
+				Who:     "root@9c1f3e2d",
+				Created: "2022-10-12 09:41:07.123456 +0000 UTC",
+			},
+		},
+		{
+			desc:   "release error",
+			output: "Error releasing the state lock\n\nLock ID: 8a3f2c1e-4b5d-6e7f-8091-a2b3c4d5e6f7",
+			lock:   &lockInfo{ID: "8a3f2c1e-4b5d-6e7f-8091-a2b3c4d5e6f7"},
+		},
+		{
+			desc: "failed task",
+			output: "TASK [write backend variables to file] ****\n\x1b[0;33mchanged: [localhost]\x1b[0m\n" +
+				"TASK [run terraform plan] ****\n\x1b[0;31mfatal: [localhost]: FAILED! => {\x1b[0m\n" +
+				"\x1b[0;31mError: error configuring Terraform AWS Provider: Throttling: Rate exceeded\x1b[0m\n" +
+				"TASK [terraform metadata] ****\nok: [localhost]\n",
+			task:   "run terraform plan",
+			failed: "fatal: [localhost]: FAILED! => {\nError: error configuring Terraform AWS Provider: Throttling: Rate exceeded",
+		},
+		{
+			desc:   "no lock",
+			output: "Apply complete! Resources: 1 added, 0 changed, 0 destroyed.\n  id = \"i-0123456789\"\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			var out bytes.Buffer
+			w := newOutputWatcher(&out)
+			// split writes across line boundaries
+			for i := 0; i < len(c.output); i += 7 {
+				end := i + 7
+				if end > len(c.output) {
+					end = len(c.output)
+				}
+				w.Write([]byte(c.output[i:end]))
+			}
+			assert.Equal(t, c.output, out.String())
+			res := w.Result()
+			assert.Equal(t, c.lock, res.lock)
+			assert.Equal(t, c.task, res.failedTask)
+			assert.Equal(t, c.failed, res.failure)
+		})
+	}
+}
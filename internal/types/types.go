@@ -3,7 +3,11 @@ package types
 import (
 	"encoding/json"
 	"fmt"
+	"path"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Environment describes the runtime environment provided by concourse
@@ -18,26 +22,68 @@ type Environment struct {
 
 // Source describes the resource configuration
 type Source struct {
-	Component string `json:"component"`
+	Backend   Backend         `json:"backend,omitempty"`
+	Component string          `json:"component"`
+	Concourse ConcourseSource `json:"concourse,omitempty"`
 	//Debug      bool              `json:"debug"`
-	Envs       map[string]string `json:"envs"`
-	PrivateKey string            `json:"private_key,omitempty"`
-	Storage    Storage           `json:"storage,omitempty"`
-	Vault      VaultSource       `json:"vault"`
+	Engine              string                   `json:"engine,omitempty"`
+	Envs                map[string]Env           `json:"envs"`
+	GitCredentials      map[string]GitCredential `json:"git_credentials,omitempty"`
+	InitCache           InitCache                `json:"init_cache,omitempty"`
+	PrivateKey          string                   `json:"private_key,omitempty"`
+	PrivateKeys         []PrivateKey             `json:"private_keys,omitempty"`
+	RegistryCredentials map[string]string        `json:"registry_credentials,omitempty"`
+	SSH                 SSHSource                `json:"ssh,omitempty"`
+	Storage             Storage                  `json:"storage,omitempty"`
+	TerraformCLI        TerraformCLI             `json:"terraform_cli,omitempty"`
+	TerraformVersions   TerraformVersions        `json:"terraform_versions,omitempty"`
+	Terragrunt          TerragruntSource         `json:"terragrunt,omitempty"`
+	Vault               VaultSource              `json:"vault"`
 }
 
 // Validate resource runtime configuration
 func (s *Source) Validate() error {
+	if err := s.Backend.Validate(); err != nil {
+		return fmt.Errorf("invalid backend config: %v", err)
+	}
 	if err := s.Vault.Validate(); err != nil {
 		return fmt.Errorf("invalid vault config: %v", err)
 	}
 	if err := s.Storage.Validate(); err != nil {
 		return fmt.Errorf("invalid storage config: %v", err)
 	}
+	switch s.EngineType() {
+	case EngineTerraform, EngineTofu:
+	case EngineTerragrunt:
+		if err := s.Terragrunt.Validate(); err != nil {
+			return fmt.Errorf("invalid terragrunt config: %v", err)
+		}
+	default:
+		return fmt.Errorf("unsupported engine (%s), expected one of: %s, %s, %s", s.Engine, EngineTerraform, EngineTofu, EngineTerragrunt)
+	}
+	if err := validatePrivateKeys(s.PrivateKeys); err != nil {
+		return err
+	}
+	for host, c := range s.GitCredentials {
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("invalid git_credentials (%s): %v", host, err)
+		}
+	}
+	for host, token := range s.RegistryCredentials {
+		if token == "" {
+			return fmt.Errorf("invalid registry_credentials (%s): missing token", host)
+		}
+	}
+	if err := s.TerraformCLI.Validate(); err != nil {
+		return fmt.Errorf("invalid terraform_cli config: %v", err)
+	}
+	if err := s.TerraformVersions.Validate(); err != nil {
+		return fmt.Errorf("invalid terraform_versions config: %v", err)
+	}
 	return nil
 }
 
-// AssignFallbackValues assigns fallback values for Component and Storage.Key based on runtime configuration
+// AssignFallbackValues assigns fallback values for Component, Storage.Key and Storage.VaultPath based on runtime configuration
 func (s *Source) AssignFallbackValues(team, component string) {
 	if s.Component == "" {
 		s.Component = component
@@ -45,6 +91,195 @@ func (s *Source) AssignFallbackValues(team, component string) {
 	if s.Storage.Key == "" {
 		s.Storage.Key = fmt.Sprintf("%s/%s/concourse-terraform-resource/version.tgz", team, s.Component)
 	}
+	if s.Storage.VaultPath == "" {
+		s.Storage.VaultPath = fmt.Sprintf("/aws/creds/%s", team)
+	}
+	if s.InitCache.Prefix == "" {
+		s.InitCache.Prefix = fmt.Sprintf("%s/concourse-terraform-resource/init-cache", team)
+	}
+}
+
+// Engines
+const (
+	EngineTerraform  = "terraform"
+	EngineTofu       = "tofu"
+	EngineTerragrunt = "terragrunt"
+)
+
+// EngineType returns the configured engine, defaulting to terraform
+func (s *Source) EngineType() string {
+	if s.Engine == "" {
+		return EngineTerraform
+	}
+	return s.Engine
+}
+
+// TerraformEngine returns the engine executing terraform operations, which
+// terragrunt delegates to terraform or tofu
+func (s *Source) TerraformEngine() string {
+	if s.EngineType() == EngineTerragrunt {
+		return s.Terragrunt.EngineType()
+	}
+	return s.EngineType()
+}
+
+// TerragruntSource describes the terragrunt engine configuration
+type TerragruntSource struct {
+	Engine string `json:"engine,omitempty"`
+	RunAll *bool  `json:"run_all,omitempty"`
+}
+
+// Validate terragrunt configuration
+func (t *TerragruntSource) Validate() error {
+	switch t.EngineType() {
+	case EngineTerraform, EngineTofu:
+	default:
+		return fmt.Errorf("unsupported engine (%s), expected one of: %s, %s", t.Engine, EngineTerraform, EngineTofu)
+	}
+	return nil
+}
+
+// EngineType returns the engine terragrunt delegates to, defaulting to terraform
+func (t *TerragruntSource) EngineType() string {
+	if t.Engine == "" {
+		return EngineTerraform
+	}
+	return t.Engine
+}
+
+// InitCache describes the persisted terraform init cache
+type InitCache struct {
+	Enabled bool   `json:"enabled"`
+	Prefix  string `json:"prefix,omitempty"`
+}
+
+// Backend modes
+const (
+	BackendModeMerge    = "merge"
+	BackendModeOverride = "override"
+)
+
+// backendType describes how a supported terraform backend is wired
+type backendType struct {
+	// keyField is the backend config field populated by key_template
+	keyField string
+	// credentials maps supported credential names to environment variables
+	credentials map[string]string
+	// vaultPath is the default vault secret path for credentials, if any
+	vaultPath string
+}
+
+// supported terraform backends, an empty type is treated as s3
+var backendTypes = map[string]backendType{
+	"s3": {
+		keyField: "key",
+		credentials: map[string]string{
+			"access_key":     "AWS_ACCESS_KEY_ID",
+			"secret_key":     "AWS_SECRET_ACCESS_KEY",
+			"security_token": "AWS_SESSION_TOKEN",
+		},
+		vaultPath: "/aws/creds/%s",
+	},
+	"gcs": {
+		keyField: "prefix",
+		credentials: map[string]string{
+			"credentials":  "GOOGLE_CREDENTIALS",
+			"access_token": "GOOGLE_OAUTH_ACCESS_TOKEN",
+		},
+		vaultPath: "/gcp/key/%s",
+	},
+	"azurerm": {
+		keyField: "key",
+		credentials: map[string]string{
+			"access_key":      "ARM_ACCESS_KEY",
+			"client_id":       "ARM_CLIENT_ID",
+			"client_secret":   "ARM_CLIENT_SECRET",
+			"sas_token":       "ARM_SAS_TOKEN",
+			"subscription_id": "ARM_SUBSCRIPTION_ID",
+			"tenant_id":       "ARM_TENANT_ID",
+		},
+		vaultPath: "/azure/creds/%s",
+	},
+	"http": {
+		keyField: "address",
+		credentials: map[string]string{
+			"username": "TF_HTTP_USERNAME",
+			"password": "TF_HTTP_PASSWORD",
+		},
+	},
+	"pg": {
+		keyField: "schema_name",
+		credentials: map[string]string{
+			"conn_str": "PG_CONN_STR",
+		},
+	},
+	"local": {
+		keyField: "path",
+	},
+}
+
+// Backend describes an optional terraform backend configuration that is
+// merged with, or overrides, the backend metadata stored in vault
+type Backend struct {
+	Type        string            `json:"type,omitempty"`
+	Config      map[string]string `json:"config,omitempty"`
+	Credentials map[string]string `json:"credentials,omitempty"`
+	KeyTemplate string            `json:"key_template,omitempty"`
+	Mode        string            `json:"mode,omitempty"`
+	VaultPath   string            `json:"vault_path,omitempty"`
+}
+
+// Validate backend configuration
+func (b *Backend) Validate() error {
+	switch b.Mode {
+	case "", BackendModeMerge, BackendModeOverride:
+	default:
+		return fmt.Errorf("invalid mode (%s), expected one of: %s, %s", b.Mode, BackendModeMerge, BackendModeOverride)
+	}
+	bt, ok := backendTypes[b.BackendType()]
+	if !ok {
+		return fmt.Errorf("unsupported type (%s)", b.Type)
+	}
+	for name := range b.Credentials {
+		if _, ok := bt.credentials[name]; !ok {
+			return fmt.Errorf("unsupported credential (%s) for %s backend", name, b.BackendType())
+		}
+	}
+	return nil
+}
+
+// BackendType returns the configured backend type, defaulting to s3
+func (b *Backend) BackendType() string {
+	if b.Type == "" {
+		return "s3"
+	}
+	return b.Type
+}
+
+// KeyField returns the backend config field used to store the state key
+func (b *Backend) KeyField() string {
+	return backendTypes[b.BackendType()].keyField
+}
+
+// CredentialEnv returns the environment variable for a named backend credential
+func (b *Backend) CredentialEnv(name string) string {
+	return backendTypes[b.BackendType()].credentials[name]
+}
+
+// CredentialsVaultPath returns the vault path credentials are read from, which
+// defaults to the team's secrets engine path when no credentials are provided
+// in source
+func (b *Backend) CredentialsVaultPath(team string) string {
+	if b.VaultPath != "" {
+		return b.VaultPath
+	}
+	if len(b.Credentials) > 0 {
+		return ""
+	}
+	if p := backendTypes[b.BackendType()].vaultPath; p != "" {
+		return fmt.Sprintf(p, team)
+	}
+	return ""
 }
 
 // EncryptField allows this value to be either bool or string,
@@ -52,6 +287,12 @@ func (s *Source) AssignFallbackValues(team, component string) {
 // our Ansible playbook
 type EncryptField string
 
+// Enabled returns true if the field is set to true
+func (f EncryptField) Enabled() bool {
+	enabled, _ := strconv.ParseBool(string(f))
+	return enabled
+}
+
 // UnmarshalJSON implements the interface expected of a nested
 // JSON field and allows a string value to be parsed from bool or string
 func (f *EncryptField) UnmarshalJSON(data []byte) (err error) {
@@ -68,32 +309,285 @@ func (f *EncryptField) UnmarshalJSON(data []byte) (err error) {
 	return json.Unmarshal([]byte(str), f)
 }
 
+// Storage types
+const (
+	StorageTypeS3    = "s3"
+	StorageTypeGCS   = "gcs"
+	StorageTypeAzure = "azure"
+	StorageTypeLocal = "local"
+)
+
+// Storage credential sources
+const (
+	CredentialSourceStatic  = "static"
+	CredentialSourceDefault = "default"
+	CredentialSourceVault   = "vault"
+)
+
 // Storage describes resource storage configuration
 type Storage struct {
-	AWSAccessKeyID     string `json:"aws_access_key_id"`
-	AWSSecretAccessKey string `json:"aws_secret_access_key"`
-	Bucket             string `json:"bucket"`
-	Key                string `json:"key"`
-	Region             string `json:"region"`
+	Type               string       `json:"type,omitempty"`
+	Account            string       `json:"account,omitempty"`
+	AccountKey         string       `json:"account_key,omitempty"`
+	AWSAccessKeyID     string       `json:"aws_access_key_id,omitempty"`
+	AWSSecretAccessKey string       `json:"aws_secret_access_key,omitempty"`
+	Bucket             string       `json:"bucket"`
+	Container          string       `json:"container,omitempty"`
+	CredentialSource   string       `json:"credential_source,omitempty"`
+	Credentials        string       `json:"credentials,omitempty"`
+	Encrypt            EncryptField `json:"encrypt,omitempty"`
+	Encryption         Encryption   `json:"encryption,omitempty"`
+	Endpoint           string       `json:"endpoint,omitempty"`
+	Key                string       `json:"key"`
+	Path               string       `json:"path,omitempty"`
+	PathStyle          bool         `json:"path_style,omitempty"`
+	Region             string       `json:"region"`
+	SSEKMSKeyID        string       `json:"sse_kms_key_id,omitempty"`
+	VaultPath          string       `json:"vault_path,omitempty"`
+}
+
+// AWSCredentialSource returns the configured s3 credential source, defaulting
+// to static if access keys are provided
+func (s *Storage) AWSCredentialSource() string {
+	if s.CredentialSource == "" && (s.AWSAccessKeyID != "" || s.AWSSecretAccessKey != "") {
+		return CredentialSourceStatic
+	}
+	return s.CredentialSource
+}
+
+// StorageType returns the configured storage type, defaulting to s3
+func (s *Storage) StorageType() string {
+	if s.Type == "" {
+		return StorageTypeS3
+	}
+	return s.Type
 }
 
 // Validate storage configuration
 func (s *Storage) Validate() error {
-	if s.AWSAccessKeyID == "" {
-		return fmt.Errorf("missing aws_access_key_id")
+	switch s.StorageType() {
+	case StorageTypeS3:
+		switch s.AWSCredentialSource() {
+		case CredentialSourceStatic:
+			if s.AWSAccessKeyID == "" {
+				return fmt.Errorf("missing aws_access_key_id")
+			}
+			if s.AWSSecretAccessKey == "" {
+				return fmt.Errorf("missing aws_secret_access_key")
+			}
+		case CredentialSourceDefault, CredentialSourceVault:
+		case "":
+			return fmt.Errorf("missing credential_source, expected one of: %s, %s, %s", CredentialSourceStatic, CredentialSourceDefault, CredentialSourceVault)
+		default:
+			return fmt.Errorf("invalid credential_source (%s), expected one of: %s, %s, %s", s.CredentialSource, CredentialSourceStatic, CredentialSourceDefault, CredentialSourceVault)
+		}
+		if s.Bucket == "" {
+			return fmt.Errorf("missing bucket")
+		}
+		if s.Region == "" {
+			return fmt.Errorf("missing region")
+		}
+	case StorageTypeGCS:
+		if s.Bucket == "" {
+			return fmt.Errorf("missing bucket")
+		}
+	case StorageTypeAzure:
+		if s.Account == "" {
+			return fmt.Errorf("missing account")
+		}
+		if s.Container == "" {
+			return fmt.Errorf("missing container")
+		}
+	case StorageTypeLocal:
+		if s.Path == "" {
+			return fmt.Errorf("missing path")
+		}
+	default:
+		return fmt.Errorf("unsupported type (%s)", s.Type)
 	}
-	if s.AWSSecretAccessKey == "" {
-		return fmt.Errorf("missing aws_secret_access_key")
+	if s.Encrypt.Enabled() {
+		if err := s.Encryption.Validate(); err != nil {
+			return fmt.Errorf("invalid encryption config: %v", err)
+		}
 	}
-	if s.Bucket == "" {
-		return fmt.Errorf("missing bucket")
+	return nil
+}
+
+// Encryption key types
+const (
+	EncryptionTypeVaultTransit = "vault_transit"
+	EncryptionTypeKMS          = "kms"
+	EncryptionTypeAge          = "age"
+)
+
+// Encryption describes the key used to encrypt version archives client side
+type Encryption struct {
+	Type                  string `json:"type"`
+	Key                   string `json:"key"`
+	Identity              string `json:"identity,omitempty"`
+	Mount                 string `json:"mount,omitempty"`
+	AllowUnencryptedReads bool   `json:"allow_unencrypted_reads,omitempty"`
+}
+
+// Validate encryption configuration
+func (e *Encryption) Validate() error {
+	switch e.Type {
+	case EncryptionTypeVaultTransit, EncryptionTypeKMS:
+	case EncryptionTypeAge:
+		if e.Identity == "" {
+			return fmt.Errorf("missing identity")
+		}
+	case "":
+		return fmt.Errorf("missing type")
+	default:
+		return fmt.Errorf("unsupported type (%s), expected one of: %s, %s, %s", e.Type, EncryptionTypeVaultTransit, EncryptionTypeKMS, EncryptionTypeAge)
 	}
-	if s.Region == "" {
-		return fmt.Errorf("missing region")
+	if e.Key == "" {
+		return fmt.Errorf("missing key")
 	}
 	return nil
 }
 
+// supported provider installation methods
+const (
+	InstallationDirect           = "direct"
+	InstallationFilesystemMirror = "filesystem_mirror"
+	InstallationNetworkMirror    = "network_mirror"
+)
+
+// TerraformCLI describes the generated terraform cli configuration
+type TerraformCLI struct {
+	DisableCheckpoint    *bool                  `json:"disable_checkpoint,omitempty"`
+	PluginCacheDir       string                 `json:"plugin_cache_dir,omitempty"`
+	ProviderInstallation []ProviderInstallation `json:"provider_installation,omitempty"`
+}
+
+// ProviderInstallation describes a provider installation method, methods are
+// consulted in order
+type ProviderInstallation struct {
+	Type    string   `json:"type"`
+	Path    string   `json:"path,omitempty"`
+	URL     string   `json:"url,omitempty"`
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Validate terraform cli configuration
+func (c *TerraformCLI) Validate() error {
+	for i, m := range c.ProviderInstallation {
+		switch m.Type {
+		case InstallationDirect:
+		case InstallationFilesystemMirror:
+			if m.Path == "" {
+				return fmt.Errorf("invalid provider_installation (%d), missing path", i)
+			}
+		case InstallationNetworkMirror:
+			if !strings.HasPrefix(m.URL, "https://") {
+				return fmt.Errorf("invalid provider_installation (%d), url must use https", i)
+			}
+		default:
+			return fmt.Errorf("invalid provider_installation (%d), unsupported type (%s)", i, m.Type)
+		}
+	}
+	return nil
+}
+
+// CheckpointDisabled returns true if upgrade and security bulletin checks are
+// disabled, defaults to true
+func (c *TerraformCLI) CheckpointDisabled() bool {
+	return c.DisableCheckpoint == nil || *c.DisableCheckpoint
+}
+
+// Directories selectable versions of each engine are installed to, as
+// <dir>/<version>/<engine>
+const (
+	DefaultTerraformVersionsDir = "/opt/terraform/versions"
+	DefaultTofuVersionsDir      = "/opt/tofu/versions"
+)
+
+// TerraformVersions describes where selectable terraform versions are
+// installed, and the local mirror missing versions are installed from
+type TerraformVersions struct {
+	Dir    string `json:"dir,omitempty"`
+	Mirror string `json:"mirror,omitempty"`
+}
+
+// Validate terraform versions configuration
+func (v *TerraformVersions) Validate() error {
+	if v.Dir != "" && !strings.HasPrefix(v.Dir, "/") {
+		return fmt.Errorf("dir must be an absolute path")
+	}
+	if v.Mirror != "" && !strings.HasPrefix(v.Mirror, "/") && !strings.HasPrefix(v.Mirror, "https://") {
+		return fmt.Errorf("mirror must be an absolute path or use https")
+	}
+	return nil
+}
+
+// VersionsDir returns the directory versions of an engine are installed to
+func (v *TerraformVersions) VersionsDir(engine string) string {
+	switch {
+	case v.Dir != "":
+		return v.Dir
+	case engine == EngineTofu:
+		return DefaultTofuVersionsDir
+	}
+	return DefaultTerraformVersionsDir
+}
+
+// GitCredential describes https credentials of a git host
+type GitCredential struct {
+	Username string `json:"username,omitempty"`
+	Token    string `json:"token"`
+}
+
+// DefaultGitUsername is used for git credentials without username, most git
+// hosts accept any username when authenticating with a token
+const DefaultGitUsername = "x-access-token"
+
+// Validate git credential
+func (c *GitCredential) Validate() error {
+	if c.Token == "" {
+		return fmt.Errorf("missing token")
+	}
+	return nil
+}
+
+// SSHSource describes ssh client configuration used to clone private modules
+type SSHSource struct {
+	Hosts                 []string `json:"hosts,omitempty"`
+	KnownHosts            string   `json:"known_hosts,omitempty"`
+	StrictHostKeyChecking *bool    `json:"strict_host_key_checking,omitempty"`
+}
+
+// DefaultSSHHosts are scanned for host keys if neither known_hosts nor hosts
+// are configured
+var DefaultSSHHosts = []string{"github.com"}
+
+// IsSet returns true if ssh is configured
+func (s *SSHSource) IsSet() bool {
+	return len(s.Hosts) > 0 || s.KnownHosts != "" || s.StrictHostKeyChecking != nil
+}
+
+// Strict returns true if host keys must be verified, defaults to true
+func (s *SSHSource) Strict() bool {
+	return s.StrictHostKeyChecking == nil || *s.StrictHostKeyChecking
+}
+
+// ScanHosts returns the hosts whose keys are scanned
+func (s *SSHSource) ScanHosts() []string {
+	if len(s.Hosts) == 0 && s.KnownHosts == "" {
+		return DefaultSSHHosts
+	}
+	return s.Hosts
+}
+
+// ConcourseSource describes optional concourse api configuration, used to
+// determine whether the build holding a state lock is still running
+type ConcourseSource struct {
+	URL   string `json:"url,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
 // VaultSource describes requried vault runtime configuration
 type VaultSource struct {
 	Addr     string `json:"addr"`
@@ -173,11 +667,35 @@ type OutRequest struct {
 	Params OutParams `json:"params"`
 }
 
+// Env describes an environment variable, which may be given as a string or
+// as an object marking its value as sensitive, so it is masked in output
+type Env struct {
+	Value     string `json:"value"`
+	Sensitive bool   `json:"sensitive,omitempty"`
+}
+
+// UnmarshalJSON implements the interface expected of a nested
+// JSON field and allows an env to be parsed from a string or object
+func (e *Env) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*e = Env{Value: str}
+		return nil
+	}
+	type env Env
+	var obj env
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*e = Env(obj)
+	return nil
+}
+
 // Envs returns the combined usm of env vars
-func (r *OutRequest) Envs() map[string]string {
+func (r *OutRequest) Envs() map[string]Env {
 	envs := r.Source.Envs
 	if envs == nil {
-		envs = map[string]string{}
+		envs = map[string]Env{}
 	}
 	if r.Params.Envs != nil && len(r.Params.Envs) > 0 {
 		for k, v := range r.Params.Envs {
@@ -187,15 +705,35 @@ func (r *OutRequest) Envs() map[string]string {
 	return envs
 }
 
-// PrivateKey extracts private key
-func (r *OutRequest) PrivateKey() (string, bool) {
+// PrivateKey describes an ssh private key, optionally protected by a
+// passphrase and accompanied by an ssh certificate
+type PrivateKey struct {
+	Key         string `json:"key"`
+	Passphrase  string `json:"passphrase,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+}
+
+// validate that every private key entry provides a key
+func validatePrivateKeys(keys []PrivateKey) error {
+	for i, k := range keys {
+		if k.Key == "" {
+			return fmt.Errorf("invalid private_keys entry (%d), missing key", i)
+		}
+	}
+	return nil
+}
+
+// PrivateKeys returns all private keys configured in source and params
+func (r *OutRequest) PrivateKeys() []PrivateKey {
+	var keys []PrivateKey
 	if r.Params.PrivateKey != "" {
-		return r.Params.PrivateKey, true
+		keys = append(keys, PrivateKey{Key: r.Params.PrivateKey})
 	}
+	keys = append(keys, r.Params.PrivateKeys...)
 	if r.Source.PrivateKey != "" {
-		return r.Source.PrivateKey, true
+		keys = append(keys, PrivateKey{Key: r.Source.PrivateKey})
 	}
-	return "", false
+	return append(keys, r.Source.PrivateKeys...)
 }
 
 // Validate out request
@@ -206,31 +744,369 @@ func (r *OutRequest) Validate() error {
 	if err := r.Params.Validate(); err != nil {
 		return fmt.Errorf("invalid params: %v", err)
 	}
+	// terragrunt modules do not select terraform workspaces, runs of several
+	// workspaces or regions would share a single state
+	if r.Source.EngineType() == EngineTerragrunt && (r.Params.Workspaces.IsSet() || len(r.Params.Regions) > 0) {
+		return fmt.Errorf("invalid params: workspaces and regions are not supported by the %s engine", EngineTerragrunt)
+	}
 	return nil
 }
 
 // OutParams describes job-level configuration for a put operation
 type OutParams struct {
-	Context        string            `json:"context"`
-	Dir            string            `json:"dir"`
-	Envs           map[string]string `json:"envs"`
-	InputMapping   string            `json:"input_mapping"`
-	PlanOnly       bool              `json:"plan_only,omitempty"`
-	PrivateKey     string            `json:"private_key,omitempty"`
-	ReleaseVersion string            `json:"release_version"`
-	VarFiles       []string          `json:"var_files"`
-	VarsMapping    string            `json:"vars_mapping"`
-	Workspace      string            `json:"workspace"`
+	Context           string         `json:"context"`
+	Destroy           bool           `json:"destroy,omitempty"`
+	Dir               string         `json:"dir"`
+	Envs              map[string]Env `json:"envs"`
+	InputMapping      string         `json:"input_mapping"`
+	PlanOnly          bool           `json:"plan_only,omitempty"`
+	PrivateKey        string         `json:"private_key,omitempty"`
+	PrivateKeys       []PrivateKey   `json:"private_keys,omitempty"`
+	ReleaseVersion    string         `json:"release_version"`
+	VarFiles          []string       `json:"var_files"`
+	VarsMapping       string         `json:"vars_mapping"`
+	Workspace         string         `json:"workspace"`
+	Workspaces        Workspaces     `json:"workspaces,omitempty"`
+	Parallelism       int            `json:"parallelism,omitempty"`
+	Stack             string         `json:"stack,omitempty"`
+	Regions           []string       `json:"regions,omitempty"`
+	ProviderOverride  string         `json:"provider_override,omitempty"`
+	FailFast          bool           `json:"fail_fast,omitempty"`
+	GracePeriod       string         `json:"grace_period,omitempty"`
+	HeartbeatInterval string         `json:"heartbeat_interval,omitempty"`
+	Timeout           string         `json:"timeout,omitempty"`
+	ForceUnlockAfter  string         `json:"force_unlock_after,omitempty"`
+	Retry             Retry          `json:"retry,omitempty"`
+	TerraformVersion  string         `json:"terraform_version,omitempty"`
+	Hooks             Hooks          `json:"hooks,omitempty"`
+	Ansible           AnsibleParams  `json:"ansible,omitempty"`
+}
+
+// AnsibleParams describes user provided extensions of the out playbook, file
+// paths are relative to the put working directory
+type AnsibleParams struct {
+	PreTasks  string            `json:"pre_tasks,omitempty"`
+	PostTasks string            `json:"post_tasks,omitempty"`
+	Playbook  string            `json:"playbook,omitempty"`
+	ExtraVars map[string]string `json:"extra_vars,omitempty"`
+}
+
+// Hooks describes commands run around terraform phases, inside the module
+// directory and with the backend credentials of the put
+type Hooks struct {
+	PreInit   []string `json:"pre_init,omitempty"`
+	PrePlan   []string `json:"pre_plan,omitempty"`
+	PostPlan  []string `json:"post_plan,omitempty"`
+	PostApply []string `json:"post_apply,omitempty"`
+	OnFailure []string `json:"on_failure,omitempty"`
 }
 
+// Stages returns the commands of each configured hook stage
+func (h *Hooks) Stages() map[string][]string {
+	stages := map[string][]string{}
+	for name, commands := range map[string][]string{
+		"pre_init":   h.PreInit,
+		"pre_plan":   h.PrePlan,
+		"post_plan":  h.PostPlan,
+		"post_apply": h.PostApply,
+		"on_failure": h.OnFailure,
+	} {
+		if len(commands) > 0 {
+			stages[name] = commands
+		}
+	}
+	return stages
+}
+
+// Validate hook commands
+func (h *Hooks) Validate() error {
+	for name, commands := range h.Stages() {
+		for i, c := range commands {
+			if strings.TrimSpace(c) == "" {
+				return fmt.Errorf("empty %s command (%d)", name, i)
+			}
+		}
+	}
+	return nil
+}
+
+// DefaultRetryErrors match transient terraform failures: api throttling, state
+// lock contention and module or provider download failures
+var DefaultRetryErrors = []string{
+	`(?i)throttl`,
+	`(?i)rate exceeded`,
+	`RequestLimitExceeded`,
+	`TooManyRequests`,
+	`Error acquiring the state lock`,
+	`(?i)failed to download module`,
+	`(?i)error downloading modules`,
+	`(?i)could not download module`,
+	`(?i)failed to (query|install) (available )?provider`,
+	`(?i)(connection reset by peer|i/o timeout|TLS handshake timeout)`,
+}
+
+// Retry describes the retry policy for transient terraform failures
+type Retry struct {
+	MaxAttempts int      `json:"max_attempts,omitempty"`
+	Backoff     string   `json:"backoff,omitempty"`
+	Errors      []string `json:"errors,omitempty"`
+	Apply       bool     `json:"apply,omitempty"`
+}
+
+const (
+	// DefaultRetryBackoff is the delay before the first retry
+	DefaultRetryBackoff = 10 * time.Second
+	// MaxRetryAttempts is the maximum number of attempts accepted
+	MaxRetryAttempts = 10
+)
+
+// Validate retry configuration
+func (r *Retry) Validate() error {
+	if r.MaxAttempts < 0 {
+		return fmt.Errorf("invalid max_attempts, must be positive")
+	}
+	if r.MaxAttempts > MaxRetryAttempts {
+		return fmt.Errorf("invalid max_attempts, must be at most %d", MaxRetryAttempts)
+	}
+	if _, err := parseDuration(r.Backoff, DefaultRetryBackoff); err != nil {
+		return fmt.Errorf("invalid backoff: %v", err)
+	}
+	for _, expr := range r.Errors {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid error pattern (%s): %v", expr, err)
+		}
+	}
+	return nil
+}
+
+// Attempts returns the maximum number of attempts, defaults to a single attempt
+func (r *Retry) Attempts() int {
+	if r.MaxAttempts < 1 {
+		return 1
+	}
+	return r.MaxAttempts
+}
+
+// BackoffDuration returns the delay before the first retry
+func (r *Retry) BackoffDuration() time.Duration {
+	d, _ := parseDuration(r.Backoff, DefaultRetryBackoff)
+	return d
+}
+
+// Patterns returns the error patterns considered retryable
+func (r *Retry) Patterns() []string {
+	if len(r.Errors) == 0 {
+		return DefaultRetryErrors
+	}
+	return r.Errors
+}
+
+const (
+	// DefaultGracePeriod is the time terraform is given to exit after an interrupt
+	DefaultGracePeriod = 60 * time.Second
+	// DefaultHeartbeatInterval is the time without output after which a
+	// heartbeat is logged
+	DefaultHeartbeatInterval = 5 * time.Minute
+	// MinHeartbeatInterval is the shortest heartbeat interval accepted
+	MinHeartbeatInterval = time.Second
+)
+
 // Validate out parameters
 func (p *OutParams) Validate() error {
-	if p.Context == "" {
+	if p.Context == "" && !p.Workspaces.IsSet() {
 		return fmt.Errorf("missing required parameter (context)")
 	}
-	if p.Dir == "" {
+	fanOut := 0
+	for _, set := range []bool{p.Workspaces.IsSet(), p.Stack != "", len(p.Regions) > 0} {
+		if set {
+			fanOut++
+		}
+	}
+	if fanOut > 1 {
+		return fmt.Errorf("invalid parameters, regions, stack and workspaces are mutually exclusive")
+	}
+	if p.ProviderOverride != "" && len(p.Regions) == 0 {
+		return fmt.Errorf("invalid parameter (provider_override), requires regions")
+	}
+	if p.Parallelism < 0 {
+		return fmt.Errorf("invalid parameter (parallelism), must be positive")
+	}
+	if p.Dir == "" && p.Stack == "" {
 		return fmt.Errorf("missing required parameter (dir)")
 	}
+	if _, err := parseDuration(p.GracePeriod, DefaultGracePeriod); err != nil {
+		return fmt.Errorf("invalid parameter (grace_period): %v", err)
+	}
+	if d, err := parseDuration(p.HeartbeatInterval, DefaultHeartbeatInterval); err != nil {
+		return fmt.Errorf("invalid parameter (heartbeat_interval): %v", err)
+	} else if d > 0 && d < MinHeartbeatInterval {
+		return fmt.Errorf("invalid parameter (heartbeat_interval), must be at least %s or 0s to disable heartbeats", MinHeartbeatInterval)
+	}
+	if _, err := parseDuration(p.Timeout, 0); err != nil {
+		return fmt.Errorf("invalid parameter (timeout): %v", err)
+	}
+	if _, err := parseDuration(p.ForceUnlockAfter, 0); err != nil {
+		return fmt.Errorf("invalid parameter (force_unlock_after): %v", err)
+	}
+	if err := p.Retry.Validate(); err != nil {
+		return fmt.Errorf("invalid parameter (retry): %v", err)
+	}
+	if err := validatePrivateKeys(p.PrivateKeys); err != nil {
+		return err
+	}
+	if err := p.Hooks.Validate(); err != nil {
+		return fmt.Errorf("invalid parameter (hooks): %v", err)
+	}
+	return nil
+}
+
+// StaleLockAge returns the age after which a state lock held by a finished
+// build is force-unlocked, zero disables force-unlocking
+func (p *OutParams) StaleLockAge() time.Duration {
+	d, _ := parseDuration(p.ForceUnlockAfter, 0)
+	return d
+}
+
+// Heartbeat returns the time without output after which a heartbeat is
+// logged, zero disables heartbeats
+func (p *OutParams) Heartbeat() time.Duration {
+	d, _ := parseDuration(p.HeartbeatInterval, DefaultHeartbeatInterval)
+	return d
+}
+
+// OperationTimeout returns the maximum duration of the put operation, zero
+// means no timeout
+func (p *OutParams) OperationTimeout() time.Duration {
+	d, _ := parseDuration(p.Timeout, 0)
+	return d
+}
+
+// InterruptGracePeriod returns the time terraform is given to exit gracefully
+// once the put is aborted
+func (p *OutParams) InterruptGracePeriod() time.Duration {
+	d, _ := parseDuration(p.GracePeriod, DefaultGracePeriod)
+	return d
+}
+
+// parse a non-negative duration, returning def if empty
+func parseDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return d, nil
+}
+
+// IsFanOut returns true if the put executes multiple terraform runs
+func (p *OutParams) IsFanOut() bool {
+	return p.Workspaces.IsSet() || p.Stack != "" || len(p.Regions) > 0
+}
+
+// WorkspaceEntry describes a single workspace of a multi-workspace put
+type WorkspaceEntry struct {
+	Workspace string                 `json:"workspace"`
+	Context   string                 `json:"context"`
+	Vars      map[string]interface{} `json:"vars,omitempty"`
+}
+
+// Workspaces allows the workspaces parameter to be either a list of
+// workspace entries or a bloblang mapping that evaluates to one
+type Workspaces struct {
+	Entries []WorkspaceEntry
+	Mapping string
+}
+
+// IsSet returns true if workspaces are configured
+func (w *Workspaces) IsSet() bool {
+	return len(w.Entries) > 0 || w.Mapping != ""
+}
+
+// UnmarshalJSON implements the interface expected of a nested
+// JSON field and allows a list of entries or a mapping string
+func (w *Workspaces) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &w.Mapping); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, &w.Entries)
+}
+
+// MarshalJSON implements json.Marshaler
+func (w Workspaces) MarshalJSON() ([]byte, error) {
+	if w.Mapping != "" {
+		return json.Marshal(w.Mapping)
+	}
+	return json.Marshal(w.Entries)
+}
+
+// StackModule describes a terraform module directory within a stack manifest
+type StackModule struct {
+	Name        string   `json:"name" yaml:"name"`
+	Dir         string   `json:"dir" yaml:"dir"`
+	DependsOn   []string `json:"depends_on" yaml:"depends_on"`
+	VarsMapping string   `json:"vars_mapping" yaml:"vars_mapping"`
+}
+
+// Stack describes a stack manifest of dependent terraform modules
+type Stack struct {
+	Modules []StackModule `json:"modules" yaml:"modules"`
+}
+
+// Validate stack manifest, module names default to the module directory name
+func (s *Stack) Validate() error {
+	if len(s.Modules) == 0 {
+		return fmt.Errorf("missing modules")
+	}
+	modules := map[string]*StackModule{}
+	for i := range s.Modules {
+		m := &s.Modules[i]
+		if m.Dir == "" {
+			return fmt.Errorf("missing dir for module (%d)", i)
+		}
+		if m.Name == "" {
+			m.Name = path.Base(m.Dir)
+		}
+		if strings.ContainsAny(m.Name, `/\`) || m.Name == "." || m.Name == ".." {
+			return fmt.Errorf("invalid module name (%s)", m.Name)
+		}
+		if _, ok := modules[m.Name]; ok {
+			return fmt.Errorf("duplicate module (%s)", m.Name)
+		}
+		modules[m.Name] = m
+	}
+
+	// detect unknown and cyclic dependencies
+	visited := map[string]int{}
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		switch visited[name] {
+		case 1:
+			return fmt.Errorf("dependency cycle (%s)", strings.Join(append(chain, name), " -> "))
+		case 2:
+			return nil
+		}
+		visited[name] = 1
+		for _, dep := range modules[name].DependsOn {
+			if _, ok := modules[dep]; !ok {
+				return fmt.Errorf("unknown dependency (%s) for module (%s)", dep, name)
+			}
+			if err := visit(dep, append(chain, name)); err != nil {
+				return err
+			}
+		}
+		visited[name] = 2
+		return nil
+	}
+	for _, m := range s.Modules {
+		if err := visit(m.Name, nil); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,114 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+)
+
+// Client is a minimal vault api client authenticated with an approle
+type Client struct {
+	addr  string
+	token string
+	http  *http.Client
+}
+
+// secret describes a vault api response
+type secret struct {
+	Auth struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Data   map[string]interface{} `json:"data"`
+	Errors []string               `json:"errors"`
+}
+
+// Login authenticates against vault using the configured approle
+func Login(cfg *types.VaultSource) (*Client, error) {
+	c := &Client{
+		addr: strings.TrimSuffix(cfg.Addr, "/"),
+		http: &http.Client{Timeout: 30 * time.Second},
+	}
+	res, err := c.do(http.MethodPost, "auth/approle/login", map[string]string{
+		"role_id":   cfg.RoleID,
+		"secret_id": cfg.SecretID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error logging into vault: %v", err)
+	}
+	if res.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("error logging into vault: no client token returned")
+	}
+	c.token = res.Auth.ClientToken
+	return c, nil
+}
+
+// Read returns the data of the secret at path
+func (c *Client) Read(path string) (map[string]interface{}, error) {
+	res, err := c.do(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return res.Data, nil
+}
+
+// Write writes data to path and returns the response data
+func (c *Client) Write(path string, data interface{}) (map[string]interface{}, error) {
+	res, err := c.do(http.MethodPost, path, data)
+	if err != nil {
+		return nil, fmt.Errorf("error writing %s: %v", path, err)
+	}
+	return res.Data, nil
+}
+
+func (c *Client) do(method, path string, body interface{}) (*secret, error) {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/v1/%s", c.addr, strings.TrimPrefix(path, "/")), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res secret
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error decoding response (%s): %v", resp.Status, err)
+	}
+	if resp.StatusCode >= 400 {
+		if len(res.Errors) > 0 {
+			return nil, fmt.Errorf("%s: %s", resp.Status, strings.Join(res.Errors, ", "))
+		}
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return &res, nil
+}
+
+// String returns a string field from secret data, or an empty string if it is
+// not set
+func String(data map[string]interface{}, key string) string {
+	if v, ok := data[key].(string); ok {
+		return v
+	}
+	return ""
+}
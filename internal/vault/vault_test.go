@@ -0,0 +1,53 @@
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adnankobir/concourse-terraform-resource/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["role_id"] != "role" || body["secret_id"] != "secret" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors":["invalid role or secret ID"]}`))
+				return
+			}
+			w.Write([]byte(`{"auth":{"client_token":"s.token"}}`))
+		case "/v1/aws/creds/sre":
+			if r.Header.Get("X-Vault-Token") != "s.token" {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			w.Write([]byte(`{"data":{"access_key":"AKIA","secret_key":"secret","security_token":null}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer srv.Close()
+
+	_, err := Login(&types.VaultSource{Addr: srv.URL, RoleID: "role", SecretID: "wrong"})
+	assert.EqualError(t, err, "error logging into vault: 400 Bad Request: invalid role or secret ID")
+
+	client, err := Login(&types.VaultSource{Addr: srv.URL + "/", RoleID: "role", SecretID: "secret"})
+	assert.NoError(t, err)
+
+	data, err := client.Read("/aws/creds/sre")
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA", String(data, "access_key"))
+	assert.Equal(t, "secret", String(data, "secret_key"))
+	assert.Equal(t, "", String(data, "security_token"))
+
+	_, err = client.Read("aws/creds/missing")
+	assert.Error(t, err)
+}